@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -17,8 +18,26 @@ type Scan struct {
 	Path     string
 	Priority int
 	Time     time.Time
+
+	// File is the path of a single changed file within the Folder, if known.
+	// Targets may scan the file instead of the whole Folder.
+	File string
+
+	// Event describes the change to the File, or to the Folder when no File is given.
+	Event Event
 }
 
+// An Event describes how the contents of a Scan changed.
+// Scans without an Event are handled as EventModified.
+type Event string
+
+const (
+	EventCreated  Event = "created"
+	EventModified Event = "modified"
+	EventDeleted  Event = "deleted"
+	EventRenamed  Event = "renamed"
+)
+
 type ProcessorFunc func(...Scan) error
 
 type Trigger func(ProcessorFunc)
@@ -36,6 +55,92 @@ type Target interface {
 	Available() error
 }
 
+// A BatchScanner is a Target which can send multiple scans in a single request.
+type BatchScanner interface {
+	ScanBatch([]Scan) error
+}
+
+// A LibraryRefresher is a Target which can refresh a whole library
+// instead of scanning the folders within it one by one.
+type LibraryRefresher interface {
+	// ScanLibraries returns the libraries a Scan would be sent to.
+	ScanLibraries(Scan) []Library
+	RefreshLibrary(Library) error
+}
+
+// An AllLibrariesRefresher is a LibraryRefresher which cannot refresh a single library,
+// so refreshing any of its libraries refreshes all of them.
+type AllLibrariesRefresher interface {
+	LibraryRefresher
+	RefreshAll() error
+}
+
+// A Verifier is a Target which can check whether a processed Scan
+// has been picked up by the target.
+type Verifier interface {
+	// Verify returns the local media files of the Scan which the target does not know.
+	Verify(Scan) ([]string, error)
+}
+
+// A ReconcileConfig schedules the reconciliation of the media files on disk
+// with the media files known to a Target.
+type ReconcileConfig struct {
+	// Interval between reconciliations. Reconciliation is disabled when not set.
+	Interval time.Duration `yaml:"interval"`
+
+	// Paths are the library roots on the local file system.
+	// The roots are derived from the libraries of the Target when not set.
+	Paths []string `yaml:"paths"`
+
+	// Priority of the scans for missing media, the lowest priority when not set.
+	Priority *int `yaml:"priority"`
+
+	// Rate is the maximum number of folders compared per second.
+	Rate float64 `yaml:"rate"`
+}
+
+// A Reconciler is a Verifier which reconciles its libraries with the media files on disk.
+type Reconciler interface {
+	Verifier
+	Reconciliation() ReconcileConfig
+
+	// LocalRoots returns the paths of the libraries on the local file system.
+	LocalRoots() []string
+}
+
+// An Explainer is a Target which can describe how it would handle a Scan
+// without sending the Scan to the target.
+// The cached libraries of the target are used, which are only retrieved again
+// when the folder of the Scan does not match any of them, at most once a minute.
+type Explainer interface {
+	Explain(Scan) Explanation
+}
+
+// An Explanation describes the outcome of a Scan for a single Target.
+type Explanation struct {
+	Target    string   `json:"target"`
+	URL       string   `json:"url,omitempty"`
+	Folder    string   `json:"folder"`
+	File      string   `json:"file,omitempty"`
+	Libraries []string `json:"libraries,omitempty"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// A Library is a single folder of a media server library,
+// as seen from the perspective of the Target.
+type Library struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// A LibraryLister is a Target which can retrieve its libraries.
+// Retrieving the libraries also refreshes the libraries used to match scans.
+type LibraryLister interface {
+	Libraries() ([]Library, error)
+}
+
 var (
 	// ErrTargetUnavailable may occur when a Target goes offline
 	// or suffers from fatal errors. In this case, the processor
@@ -53,6 +158,11 @@ var (
 	// not available on the file system. Processing should halt
 	// until all anchors are available.
 	ErrAnchorUnavailable = errors.New("anchor file is unavailable")
+
+	// ErrQueueFull indicates that the Processor rejected scans
+	// as the queue has reached its maximum size.
+	// Triggers should try again later.
+	ErrQueueFull = errors.New("queue is full")
 )
 
 type Rewrite struct {
@@ -86,6 +196,31 @@ func NewRewriter(rewriteRules []Rewrite) (Rewriter, error) {
 	return rewriter, nil
 }
 
+// NewReverseRewriter returns a Rewriter which undoes the rewrite rules,
+// for example to turn the paths of the libraries of a Target into local paths.
+// Only rules rewriting a literal path to a literal path can be undone, other rules are ignored.
+func NewReverseRewriter(rewriteRules []Rewrite) (Rewriter, error) {
+	reversed := make([]Rewrite, 0, len(rewriteRules))
+	for _, rule := range rewriteRules {
+		re, err := regexp.Compile(strings.TrimPrefix(rule.From, "^"))
+		if err != nil {
+			return nil, err
+		}
+
+		from, literal := re.LiteralPrefix()
+		if !literal || strings.Contains(rule.To, "$") {
+			continue
+		}
+
+		reversed = append(reversed, Rewrite{
+			From: "^" + regexp.QuoteMeta(rule.To),
+			To:   strings.ReplaceAll(from, "$", "$$"),
+		})
+	}
+
+	return NewRewriter(reversed)
+}
+
 type Filterer func(string) bool
 
 func NewFilterer(includes []string, excludes []string) (Filterer, error) {
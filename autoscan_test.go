@@ -99,3 +99,74 @@ func TestRewriter(t *testing.T) {
 	}
 
 }
+
+func TestReverseRewriter(t *testing.T) {
+	type Test struct {
+		Name     string
+		Rewrites []Rewrite
+		Input    string
+		Expected string
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Literal path",
+			Input:    "/data/Movies/",
+			Expected: "/mnt/unionfs/Media/Movies/",
+			Rewrites: []Rewrite{{
+				From: "/mnt/unionfs/Media/",
+				To:   "/data/",
+			}},
+		},
+		{
+			Name:     "Anchored literal path",
+			Input:    "/Movies/",
+			Expected: "/Media/Movies/",
+			Rewrites: []Rewrite{{
+				From: "^/Media/",
+				To:   "/",
+			}},
+		},
+		{
+			Name:     "Uses the rule which matches",
+			Input:    "/mnt/unionfs/movies4k/",
+			Expected: "/movies4k/",
+			Rewrites: []Rewrite{
+				{From: "^/movies/", To: "/mnt/unionfs/movies/"},
+				{From: "^/movies4k/", To: "/mnt/unionfs/movies4k/"},
+			},
+		},
+		{
+			Name:     "Ignores rules with patterns",
+			Input:    "/data/Movies/",
+			Expected: "/data/Movies/",
+			Rewrites: []Rewrite{{
+				From: "/Media/(.*)",
+				To:   "/data/$1",
+			}},
+		},
+		{
+			Name:     "Returns input when rule does not match",
+			Input:    "/data/Movies/",
+			Expected: "/data/Movies/",
+			Rewrites: []Rewrite{{
+				From: "^/Media/",
+				To:   "/mnt/unionfs/Media/",
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rewriter, err := NewReverseRewriter(tc.Rewrites)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := rewriter(tc.Input)
+			if result != tc.Expected {
+				t.Errorf("%s does not equal %s", result, tc.Expected)
+			}
+		})
+	}
+}
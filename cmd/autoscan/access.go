@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/time/rate"
+)
+
+type accessConfig struct {
+	// TrustedProxies are the networks allowed to set the X-Forwarded-For header.
+	// Connections over a unix socket are always trusted.
+	TrustedProxies []string `yaml:"trusted-proxies"`
+
+	// Allow limits the clients which may access a route.
+	// Routes without a matching rule are accessible from everywhere.
+	Allow []allowRule `yaml:"allow"`
+
+	// RateLimit limits the number of requests per client,
+	// Rate is given in requests per second.
+	RateLimit struct {
+		Rate  float64 `yaml:"rate"`
+		Burst int     `yaml:"burst"`
+	} `yaml:"rate-limit"`
+}
+
+// An allowRule allows the clients within the CIDRs to access the routes,
+// routes are given as scopes, e.g. triggers or triggers/sonarr.
+// A rule without routes applies to all routes.
+type allowRule struct {
+	Routes []string `yaml:"routes"`
+	CIDRs  []string `yaml:"cidrs"`
+}
+
+// rateLimitIdle is the time after which the limiter of an idle client is removed.
+const rateLimitIdle = 10 * time.Minute
+
+type accessController struct {
+	trusted []*net.IPNet
+	rules   []accessRule
+
+	rate  rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastPrune time.Time
+}
+
+type accessRule struct {
+	routes []string
+	nets   []*net.IPNet
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// parseCIDRs parses CIDRs and single IP addresses.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %v", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newAccessController returns nil when access to the routes is not limited.
+func newAccessController(c accessConfig) (*accessController, error) {
+	if len(c.Allow) == 0 && c.RateLimit.Rate <= 0 {
+		return nil, nil
+	}
+
+	if c.RateLimit.Rate < 0 || c.RateLimit.Burst < 0 {
+		return nil, errors.New("access: rate limit must be positive")
+	}
+
+	trusted, err := parseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("access: trusted proxies: %w", err)
+	}
+
+	rules := make([]accessRule, 0, len(c.Allow))
+	for _, rule := range c.Allow {
+		for _, route := range rule.Routes {
+			if route != scopeTriggers && !strings.HasPrefix(route, scopeTriggers+"/") {
+				return nil, fmt.Errorf("access: unknown route: %v", route)
+			}
+		}
+
+		nets, err := parseCIDRs(rule.CIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("access: %w", err)
+		}
+
+		rules = append(rules, accessRule{
+			routes: rule.Routes,
+			nets:   nets,
+		})
+	}
+
+	ac := &accessController{
+		trusted: trusted,
+		rules:   rules,
+		rate:    rate.Limit(c.RateLimit.Rate),
+		burst:   c.RateLimit.Burst,
+		clients: make(map[string]*client),
+	}
+
+	if ac.burst == 0 {
+		ac.burst = int(math.Max(1, math.Ceil(c.RateLimit.Rate)))
+	}
+
+	return ac, nil
+}
+
+// clientIP returns the address of the client.
+// The X-Forwarded-For header is only used when the request is made by a trusted proxy,
+// in which case the header is walked from right to left up to the first untrusted address.
+func (a *accessController) clientIP(r *http.Request) net.IP {
+	var remote net.IP
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remote = net.ParseIP(host)
+	}
+
+	// unix sockets do not have a remote address and can only be reached locally
+	if remote != nil && !containsIP(a.trusted, remote) {
+		return remote
+	}
+
+	forwarded := make([]string, 0)
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	ip := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if addr == nil {
+			break
+		}
+
+		ip = addr
+		if !containsIP(a.trusted, addr) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// allowed checks whether the client may access the scope of a request.
+func (a *accessController) allowed(scope string, ip net.IP) bool {
+	applies := false
+	for _, rule := range a.rules {
+		if len(rule.routes) > 0 && !(credential{Scopes: rule.routes}).allows(scope) {
+			continue
+		}
+
+		applies = true
+		if ip != nil && containsIP(rule.nets, ip) {
+			return true
+		}
+	}
+
+	return !applies
+}
+
+func (a *accessController) limiter(key string) *rate.Limiter {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastPrune) > rateLimitIdle {
+		for k, c := range a.clients {
+			if now.Sub(c.lastSeen) > rateLimitIdle {
+				delete(a.clients, k)
+			}
+		}
+
+		a.lastPrune = now
+	}
+
+	c, ok := a.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(a.rate, a.burst)}
+		a.clients[key] = c
+	}
+
+	c.lastSeen = now
+	return c.limiter
+}
+
+// Middleware rejects requests from clients which are not allowed to access the route
+// and limits the number of requests per client.
+// The address of the client is added to the request logger.
+func (a *accessController) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ip := a.clientIP(r)
+
+		rlog := hlog.FromRequest(r)
+		rlog.UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.IPAddr("client", ip)
+		})
+
+		scope := requestScope(r)
+		if !a.allowed(scope, ip) {
+			rlog.Warn().Str("scope", scope).Msg("Client is not allowed to access this route")
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if a.rate > 0 {
+			reservation := a.limiter(ip.String()).Reserve()
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+
+				rlog.Warn().Msg("Client exceeded the rate limit")
+				rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				rw.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
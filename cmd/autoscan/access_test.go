@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	type Given struct {
+		RemoteAddr string
+		Forwarded  []string
+	}
+
+	type Test struct {
+		Name     string
+		Given    Given
+		Expected string
+	}
+
+	var testCases = []Test{
+		{
+			"Uses the remote address without a proxy",
+			Given{RemoteAddr: "203.0.113.5:1234"},
+			"203.0.113.5",
+		},
+		{
+			"Ignores the header set by an untrusted client",
+			Given{RemoteAddr: "203.0.113.5:1234", Forwarded: []string{"10.0.0.1"}},
+			"203.0.113.5",
+		},
+		{
+			"Uses the header set by a trusted proxy",
+			Given{RemoteAddr: "172.18.0.2:1234", Forwarded: []string{"198.51.100.7"}},
+			"198.51.100.7",
+		},
+		{
+			"Ignores addresses spoofed before the first untrusted address",
+			Given{RemoteAddr: "172.18.0.2:1234", Forwarded: []string{"10.0.0.1, 198.51.100.7"}},
+			"198.51.100.7",
+		},
+		{
+			"Walks through multiple trusted proxies",
+			Given{RemoteAddr: "172.18.0.2:1234", Forwarded: []string{"198.51.100.7, 172.18.0.3", "172.18.0.4"}},
+			"198.51.100.7",
+		},
+		{
+			"Stops at an invalid address",
+			Given{RemoteAddr: "172.18.0.2:1234", Forwarded: []string{"10.0.0.1, invalid"}},
+			"172.18.0.2",
+		},
+		{
+			"Uses the remote address of a trusted proxy without the header",
+			Given{RemoteAddr: "172.18.0.2:1234"},
+			"172.18.0.2",
+		},
+		{
+			"Trusts the header of a unix socket",
+			Given{RemoteAddr: "@", Forwarded: []string{"198.51.100.7"}},
+			"198.51.100.7",
+		},
+	}
+
+	ac, err := newAccessController(accessConfig{
+		TrustedProxies: []string{"172.18.0.0/16"},
+		Allow:          []allowRule{{CIDRs: []string{"0.0.0.0/0"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/triggers/sonarr", nil)
+			req.RemoteAddr = tc.Given.RemoteAddr
+			for _, header := range tc.Given.Forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+
+			ip := ac.clientIP(req)
+			if ip.String() != tc.Expected {
+				t.Errorf("Client addresses do not match: %v vs %v (expected)", ip, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	type Given struct {
+		Path       string
+		RemoteAddr string
+		Forwarded  string
+	}
+
+	type Test struct {
+		Name     string
+		Given    Given
+		Expected int
+	}
+
+	var testCases = []Test{
+		{
+			"Allows a client within the CIDR of the route",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "192.168.1.10:1234"},
+			http.StatusOK,
+		},
+		{
+			"Denies a client outside of the CIDRs of the route",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "198.51.100.7:1234"},
+			http.StatusForbidden,
+		},
+		{
+			"Allows a single address",
+			Given{Path: "/triggers/a-train/drive-id", RemoteAddr: "203.0.113.5:1234"},
+			http.StatusOK,
+		},
+		{
+			"Denies a client allowed on another route",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "203.0.113.5:1234"},
+			http.StatusForbidden,
+		},
+		{
+			"Allows a client by any rule matching the route",
+			Given{Path: "/triggers/a-train/drive-id", RemoteAddr: "192.168.1.10:1234"},
+			http.StatusOK,
+		},
+		{
+			"Allows routes without rules from everywhere",
+			Given{Path: "/api/history", RemoteAddr: "203.0.113.5:1234"},
+			http.StatusOK,
+		},
+		{
+			"Denies a spoofed header from an untrusted client",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "203.0.113.5:1234", Forwarded: "192.168.1.10"},
+			http.StatusForbidden,
+		},
+		{
+			"Denies a spoofed header passed on by a trusted proxy",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "172.18.0.2:1234", Forwarded: "192.168.1.10, 203.0.113.5"},
+			http.StatusForbidden,
+		},
+		{
+			"Allows a client behind a trusted proxy",
+			Given{Path: "/triggers/sonarr", RemoteAddr: "172.18.0.2:1234", Forwarded: "192.168.1.10"},
+			http.StatusOK,
+		},
+	}
+
+	ac, err := newAccessController(accessConfig{
+		TrustedProxies: []string{"172.18.0.0/16"},
+		Allow: []allowRule{
+			{Routes: []string{scopeTriggers}, CIDRs: []string{"192.168.1.0/24"}},
+			{Routes: []string{"triggers/a-train"}, CIDRs: []string{"203.0.113.5"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := ac.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.Given.Path, nil)
+			req.RemoteAddr = tc.Given.RemoteAddr
+			if tc.Given.Forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.Given.Forwarded)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.Expected {
+				t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c := accessConfig{}
+	c.RateLimit.Rate = 0.001
+	c.RateLimit.Burst = 2
+
+	ac, err := newAccessController(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := ac.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/triggers/sonarr", nil)
+		req.RemoteAddr = remoteAddr
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := request("203.0.113.5:1234"); rr.Code != http.StatusOK {
+			t.Fatalf("Expected request %d within the burst to succeed, got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := request("203.0.113.5:4321")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the request exceeding the burst to be limited, got %d", rr.Code)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	// clients are limited separately
+	if rr := request("198.51.100.7:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another client not to be limited, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/cloudbox/autoscan"
+	"github.com/cloudbox/autoscan/processor"
+)
+
+func apiRoutes(r chi.Router, proc *processor.Processor, targets []autoscan.Target) {
+	r.Get("/stats", statsHandler(proc))
+	r.Get("/history", historyHandler(proc))
+	r.Post("/libraries/refresh", librariesHandler(targets))
+}
+
+func statsHandler(proc *processor.Processor) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		remaining, err := proc.ScansRemaining()
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Msg("Failed determining amount of remaining scans")
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(rw, r, map[string]int64{
+			"remaining": int64(remaining),
+			"processed": proc.ScansProcessed(),
+		})
+	}
+}
+
+func historyHandler(proc *processor.Processor) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		history, err := proc.History(limit)
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Msg("Failed retrieving history")
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(rw, r, history)
+	}
+}
+
+// targetLibraries are the libraries of a single target.
+type targetLibraries struct {
+	Target    string             `json:"target"`
+	URL       string             `json:"url,omitempty"`
+	Libraries []autoscan.Library `json:"libraries"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// librariesHandler retrieves the libraries of all targets which support it,
+// which also refreshes the libraries used to match scans.
+func librariesHandler(targets []autoscan.Target) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		results := make([]targetLibraries, 0, len(targets))
+		for _, t := range targets {
+			t = unwrapTarget(t)
+
+			lister, ok := t.(autoscan.LibraryLister)
+			if !ok {
+				continue
+			}
+
+			result := targetLibraries{Target: fmt.Sprintf("%T", t)}
+			if explainer, ok := t.(autoscan.Explainer); ok {
+				e := explainer.Explain(autoscan.Scan{})
+				result.Target, result.URL = e.Target, e.URL
+			}
+
+			libraries, err := lister.Libraries()
+			if err != nil {
+				hlog.FromRequest(r).Warn().
+					Err(err).
+					Str("target", result.Target).
+					Msg("Failed refreshing libraries")
+
+				result.Error = err.Error()
+			}
+
+			result.Libraries = libraries
+			results = append(results, result)
+		}
+
+		writeJSON(rw, r, results)
+	}
+}
+
+// unwrapTarget returns the target wrapped by the dry-run mode.
+func unwrapTarget(t autoscan.Target) autoscan.Target {
+	if u, ok := t.(interface{ Unwrap() autoscan.Target }); ok {
+		return u.Unwrap()
+	}
+
+	return t
+}
+
+func writeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("Failed encoding response")
+	}
+}
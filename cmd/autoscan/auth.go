@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// scopeAdmin grants access to the API under /api.
+	scopeAdmin = "admin"
+	// scopeTriggers grants access to all routes under /triggers,
+	// a single trigger is given access with triggers/{route}, e.g. triggers/sonarr.
+	scopeTriggers = "triggers"
+)
+
+// tokenHashPrefix marks a token given as the hex encoded SHA-256 of the token.
+const tokenHashPrefix = "sha256:"
+
+const (
+	// maxAuthFailures is the number of failed authentications of a client within the authFailureWindow,
+	// after which its requests are rejected before their credentials are compared.
+	maxAuthFailures   = 10
+	authFailureWindow = time.Minute
+)
+
+// A credential authenticates requests with either basic auth or a token.
+// Passwords may be given in plain text or as a bcrypt hash,
+// tokens in plain text or as their SHA-256 prefixed with sha256:.
+type credential struct {
+	Name     string   `yaml:"name"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Token    string   `yaml:"token"`
+	Scopes   []string `yaml:"scopes"`
+}
+
+func (c credential) validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("credential without a name")
+	case c.Token == "" && (c.Username == "" || c.Password == ""):
+		return fmt.Errorf("%v: either a token or a username and password must be given", c.Name)
+	case isBcryptHash(c.Token):
+		// every request would compare its token with every bcrypt hash
+		return fmt.Errorf("%v: tokens cannot be given as a bcrypt hash, use %v<hex encoded SHA-256> instead", c.Name, tokenHashPrefix)
+	}
+
+	if _, err := c.tokenHash(); err != nil {
+		return fmt.Errorf("%v: %w", c.Name, err)
+	}
+
+	for _, scope := range c.Scopes {
+		if scope != scopeAdmin && scope != scopeTriggers && !strings.HasPrefix(scope, scopeTriggers+"/") {
+			return fmt.Errorf("%v: unknown scope: %v", c.Name, scope)
+		}
+	}
+
+	return nil
+}
+
+// tokenHash returns the SHA-256 of the token.
+func (c credential) tokenHash() ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	if !strings.HasPrefix(c.Token, tokenHashPrefix) {
+		return sha256.Sum256([]byte(c.Token)), nil
+	}
+
+	b, err := hex.DecodeString(strings.TrimPrefix(c.Token, tokenHashPrefix))
+	if err != nil || len(b) != sha256.Size {
+		return hash, errors.New("token is not a hex encoded SHA-256")
+	}
+
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// allows checks whether the credential has access to the scope of a request.
+func (c credential) allows(scope string) bool {
+	if len(c.Scopes) == 0 {
+		return true
+	}
+
+	for _, s := range c.Scopes {
+		if scope == s || strings.HasPrefix(scope, s+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCredentials returns the configured credentials.
+// The authentication field is used as a credential with access to all routes.
+func getCredentials(c config) ([]credential, error) {
+	creds := make([]credential, 0, len(c.Credentials)+1)
+	if c.Auth.Username != "" && c.Auth.Password != "" {
+		creds = append(creds, credential{
+			Name:     c.Auth.Username,
+			Username: c.Auth.Username,
+			Password: c.Auth.Password,
+		})
+	}
+
+	names := make(map[string]bool)
+	for _, cred := range c.Credentials {
+		if err := cred.validate(); err != nil {
+			return nil, err
+		}
+
+		if names[cred.Name] {
+			return nil, fmt.Errorf("%v: duplicate credential name", cred.Name)
+		}
+
+		names[cred.Name] = true
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+type authenticator struct {
+	credentials []credential
+
+	// tokens holds the index of the credential by the SHA-256 of its token,
+	// so a token is found without comparing it with every credential.
+	tokens map[[sha256.Size]byte]int
+
+	// access determines the address of the client when access is limited.
+	access *accessController
+
+	mu sync.Mutex
+
+	// verified caches the sha256 of passwords which matched a bcrypt hash,
+	// as comparing bcrypt hashes is slow on purpose.
+	verified map[[sha256.Size]byte]bool
+
+	// failures holds the failed authentications by client.
+	failures  map[string]*authFailures
+	lastPrune time.Time
+}
+
+type authFailures struct {
+	count int
+	since time.Time
+}
+
+// newAuthenticator expects validated credentials, access may be nil.
+func newAuthenticator(creds []credential, access *accessController) *authenticator {
+	a := &authenticator{
+		credentials: creds,
+		tokens:      make(map[[sha256.Size]byte]int),
+		access:      access,
+		verified:    make(map[[sha256.Size]byte]bool),
+		failures:    make(map[string]*authFailures),
+	}
+
+	for i, cred := range creds {
+		if cred.Token == "" {
+			continue
+		}
+
+		if hash, err := cred.tokenHash(); err == nil {
+			a.tokens[hash] = i
+		}
+	}
+
+	return a
+}
+
+func isBcryptHash(secret string) bool {
+	return strings.HasPrefix(secret, "$2a$") || strings.HasPrefix(secret, "$2b$") || strings.HasPrefix(secret, "$2y$")
+}
+
+func (a *authenticator) compare(hash string, secret string) bool {
+	if !isBcryptHash(hash) {
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(secret)) == 1
+	}
+
+	key := sha256.Sum256([]byte(hash + "\x00" + secret))
+
+	a.mu.Lock()
+	verified := a.verified[key]
+	a.mu.Unlock()
+
+	if verified {
+		return true
+	}
+
+	// compared without holding the lock, so a slow comparison does not hold up other requests
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	a.verified[key] = true
+	a.mu.Unlock()
+
+	return true
+}
+
+// authenticate returns the credential matching the request.
+func (a *authenticator) authenticate(r *http.Request) (*credential, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		for i, cred := range a.credentials {
+			if cred.Username != "" && cred.Username == username && a.compare(cred.Password, password) {
+				return &a.credentials[i], true
+			}
+		}
+
+		return nil, false
+	}
+
+	token := r.Header.Get("X-Api-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if token == "" {
+		return nil, false
+	}
+
+	i, ok := a.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, false
+	}
+
+	return &a.credentials[i], true
+}
+
+// clientKey identifies the client of the request for counting its failed authentications.
+func (a *authenticator) clientKey(r *http.Request) string {
+	if a.access != nil {
+		return a.access.clientIP(r).String()
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// blocked returns the time until the client may authenticate again,
+// when it failed to authenticate too often.
+func (a *authenticator) blocked(key string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.failures[key]
+	if !ok || f.count < maxAuthFailures {
+		return 0
+	}
+
+	remaining := authFailureWindow - time.Since(f.since)
+	if remaining <= 0 {
+		delete(a.failures, key)
+		return 0
+	}
+
+	return remaining
+}
+
+// fail counts a failed authentication of the client.
+func (a *authenticator) fail(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastPrune) > authFailureWindow {
+		for k, f := range a.failures {
+			if now.Sub(f.since) > authFailureWindow {
+				delete(a.failures, k)
+			}
+		}
+
+		a.lastPrune = now
+	}
+
+	f, ok := a.failures[key]
+	if !ok || now.Sub(f.since) > authFailureWindow {
+		f = &authFailures{since: now}
+		a.failures[key] = f
+	}
+
+	f.count++
+}
+
+// requestScope returns the scope required for the request,
+// e.g. /triggers/a-train/{drive} requires triggers/a-train.
+func requestScope(r *http.Request) string {
+	p := strings.Trim(r.URL.Path, "/")
+	switch {
+	case p == "api" || strings.HasPrefix(p, "api/"):
+		return scopeAdmin
+	case strings.HasPrefix(p, "triggers/"):
+		route := strings.SplitN(strings.TrimPrefix(p, "triggers/"), "/", 2)[0]
+		return scopeTriggers + "/" + route
+	}
+
+	return p
+}
+
+// Middleware only allows requests authenticated by a credential
+// with access to the scope of the request.
+// Clients which failed to authenticate too often are rejected with a 429 for a while.
+// The name of the credential is added to the request logger.
+func (a *authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rlog := hlog.FromRequest(r)
+
+		key := a.clientKey(r)
+		if wait := a.blocked(key); wait > 0 {
+			rlog.Warn().Msg("Client failed to authenticate too often")
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		cred, ok := a.authenticate(r)
+		if !ok {
+			a.fail(key)
+			rw.Header().Add("WWW-Authenticate", `Basic realm="Autoscan 1.x"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		rlog.UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("credential", cred.Name)
+		})
+
+		scope := requestScope(r)
+		if !cred.allows(scope) {
+			rlog.Warn().Str("scope", scope).Msg("Credential is not allowed to access this route")
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
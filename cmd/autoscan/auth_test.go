@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticator(t *testing.T) {
+	type Given struct {
+		Path     string
+		Username string
+		Password string
+		Token    string
+		Bearer   string
+	}
+
+	type Test struct {
+		Name     string
+		Given    Given
+		Expected int
+	}
+
+	hash := func(secret string) string {
+		b, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(b)
+	}
+
+	creds := []credential{
+		{Name: "legacy", Username: "legacy", Password: "legacy-pass"},
+		{Name: "admin", Token: "admin-token", Scopes: []string{scopeAdmin}},
+		{Name: "triggers", Token: tokenHashPrefix + sha256Hex("triggers-token"), Scopes: []string{scopeTriggers}},
+		{Name: "sonarr", Username: "sonarr", Password: hash("sonarr-pass"), Scopes: []string{"triggers/sonarr"}},
+	}
+
+	var testCases = []Test{
+		{
+			"Denies requests without credentials",
+			Given{Path: "/triggers/sonarr"},
+			http.StatusUnauthorized,
+		},
+		{
+			"Allows a credential without scopes on every route",
+			Given{Path: "/api/history", Username: "legacy", Password: "legacy-pass"},
+			http.StatusOK,
+		},
+		{
+			"Denies a wrong plain text password",
+			Given{Path: "/triggers/sonarr", Username: "legacy", Password: "legacy"},
+			http.StatusUnauthorized,
+		},
+		{
+			"Allows the admin scope on the API",
+			Given{Path: "/api/history", Token: "admin-token"},
+			http.StatusOK,
+		},
+		{
+			"Allows a bearer token",
+			Given{Path: "/api/history", Bearer: "admin-token"},
+			http.StatusOK,
+		},
+		{
+			"Denies the admin scope on triggers",
+			Given{Path: "/triggers/sonarr", Token: "admin-token"},
+			http.StatusForbidden,
+		},
+		{
+			"Denies an unknown token",
+			Given{Path: "/api/history", Token: "admin"},
+			http.StatusUnauthorized,
+		},
+		{
+			"Allows the triggers scope on every trigger with a hashed token",
+			Given{Path: "/triggers/a-train/drive-id", Token: "triggers-token"},
+			http.StatusOK,
+		},
+		{
+			"Denies the triggers scope on the API",
+			Given{Path: "/api/history", Token: "triggers-token"},
+			http.StatusForbidden,
+		},
+		{
+			"Denies the hash itself as a token",
+			Given{Path: "/triggers/sonarr", Token: creds[2].Token},
+			http.StatusUnauthorized,
+		},
+		{
+			"Allows a single trigger scope on its trigger with a bcrypt password",
+			Given{Path: "/triggers/sonarr", Username: "sonarr", Password: "sonarr-pass"},
+			http.StatusOK,
+		},
+		{
+			"Denies a single trigger scope on other triggers",
+			Given{Path: "/triggers/radarr", Username: "sonarr", Password: "sonarr-pass"},
+			http.StatusForbidden,
+		},
+		{
+			"Denies a single trigger scope on triggers sharing its prefix",
+			Given{Path: "/triggers/sonarr4k", Username: "sonarr", Password: "sonarr-pass"},
+			http.StatusForbidden,
+		},
+		{
+			"Denies a wrong bcrypt password",
+			Given{Path: "/triggers/sonarr", Username: "sonarr", Password: "sonarr"},
+			http.StatusUnauthorized,
+		},
+		{
+			"Denies the password of another user",
+			Given{Path: "/triggers/sonarr", Username: "legacy", Password: "sonarr-pass"},
+			http.StatusUnauthorized,
+		},
+	}
+
+	auth := newAuthenticator(creds, nil)
+	handler := auth.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.Given.Path, nil)
+			if tc.Given.Username != "" {
+				req.SetBasicAuth(tc.Given.Username, tc.Given.Password)
+			}
+
+			if tc.Given.Token != "" {
+				req.Header.Set("X-Api-Key", tc.Given.Token)
+			}
+
+			if tc.Given.Bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.Given.Bearer)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.Expected {
+				t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, tc.Expected)
+			}
+		})
+	}
+}
+
+func sha256Hex(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestAuthenticatorFailures(t *testing.T) {
+	auth := newAuthenticator([]credential{{Name: "admin", Token: "admin-token"}}, nil)
+	handler := auth.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(remote string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/history", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Api-Key", token)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < maxAuthFailures; i++ {
+		if rr := request("192.0.2.1:1234", "wrong"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Status codes do not match: %d vs %d (expected)", rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rr := request("192.0.2.1:4321", "admin-token")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, http.StatusTooManyRequests)
+	}
+
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Unexpected Retry-After: %q", rr.Header().Get("Retry-After"))
+	}
+
+	if rr := request("192.0.2.2:1234", "admin-token"); rr.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be allowed, got %d", rr.Code)
+	}
+
+	// the window of the client has passed
+	auth.failures["192.0.2.1"].since = time.Now().Add(-authFailureWindow)
+	if rr := request("192.0.2.1:1234", "admin-token"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the client to be allowed after the window, got %d", rr.Code)
+	}
+}
+
+func TestCredentialValidate(t *testing.T) {
+	type Test struct {
+		Name       string
+		Credential credential
+		Valid      bool
+	}
+
+	var testCases = []Test{
+		{
+			"Accepts a token",
+			credential{Name: "token", Token: "XXXX", Scopes: []string{scopeAdmin}},
+			true,
+		},
+		{
+			"Accepts a username and password",
+			credential{Name: "basic", Username: "user", Password: "pass", Scopes: []string{"triggers/sonarr"}},
+			true,
+		},
+		{
+			"Accepts a SHA-256 token",
+			credential{Name: "token", Token: tokenHashPrefix + sha256Hex("XXXX")},
+			true,
+		},
+		{
+			"Rejects an invalid SHA-256 token",
+			credential{Name: "token", Token: tokenHashPrefix + "XXXX"},
+			false,
+		},
+		{
+			"Rejects a bcrypt token",
+			credential{Name: "token", Token: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+			false,
+		},
+		{
+			"Rejects a credential without a name",
+			credential{Token: "XXXX"},
+			false,
+		},
+		{
+			"Rejects a username without a password",
+			credential{Name: "basic", Username: "user"},
+			false,
+		},
+		{
+			"Rejects unknown scopes",
+			credential{Name: "token", Token: "XXXX", Scopes: []string{"api"}},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Credential.validate()
+			if (err == nil) != tc.Valid {
+				t.Errorf("Unexpected validation result: %v", err)
+			}
+		})
+	}
+}
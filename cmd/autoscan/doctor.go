@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudbox/autoscan"
+	"github.com/cloudbox/autoscan/processor"
+)
+
+type doctorCmd struct {
+	Scans int `default:"100" help:"Number of recently queued scans to check"`
+}
+
+// A sample is a local path which should be known to the targets.
+type sample struct {
+	Source string
+	Folder string
+
+	// Root samples only need to contain a library, e.g. the folder of an anchor file.
+	Root bool
+}
+
+func (cmd doctorCmd) run(c config, proc *processor.Processor) error {
+	samples, err := cmd.samples(c, proc)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	for _, t := range getTargets(c) {
+		// dry-run targets resolve libraries like any other target
+		t = unwrapTarget(t)
+
+		lister, isLister := t.(autoscan.LibraryLister)
+		explainer, isExplainer := t.(autoscan.Explainer)
+
+		switch {
+		case isLister && isExplainer:
+		case isExplainer:
+			target := explainer.Explain(autoscan.Scan{})
+			fmt.Fprintf(w, "%s (%s)\n  - cannot diagnose, the target does not list its libraries\n\n", target.Target, target.URL)
+			continue
+		case isLister:
+			fmt.Fprintf(w, "%T\n  - cannot diagnose, the target does not explain its scans\n\n", t)
+			continue
+		default:
+			continue
+		}
+
+		target := explainer.Explain(autoscan.Scan{})
+		fmt.Fprintf(w, "%s (%s)\n", target.Target, target.URL)
+
+		libraries, err := lister.Libraries()
+		if err != nil {
+			fmt.Fprintf(w, "  ✗ failed retrieving libraries: %v\n\n", err)
+			continue
+		}
+
+		for _, lib := range libraries {
+			fmt.Fprintf(w, "  library %q: %s\n", lib.Name, lib.Path)
+		}
+
+		diagnose(w, explainer, libraries, samples)
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+func (cmd doctorCmd) samples(c config, proc *processor.Processor) ([]sample, error) {
+	samples := make([]sample, 0)
+	unique := make(map[string]bool)
+
+	add := func(s sample) {
+		if s.Folder == "" || unique[s.Folder] {
+			return
+		}
+
+		unique[s.Folder] = true
+		samples = append(samples, s)
+	}
+
+	for _, t := range c.Triggers.Inotify {
+		for _, p := range t.Paths {
+			rewriter, err := autoscan.NewRewriter(concat(p.Rewrite, t.Rewrite))
+			if err != nil {
+				return nil, fmt.Errorf("inotify: %w", err)
+			}
+
+			add(sample{Source: "inotify", Folder: filepath.Clean(rewriter(p.Path)), Root: true})
+		}
+	}
+
+	for _, anchor := range c.Anchors {
+		add(sample{Source: "anchor", Folder: filepath.Dir(anchor), Root: true})
+	}
+
+	scans, err := proc.RecentScans(cmd.Scans)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scan := range scans {
+		add(sample{Source: "queue", Folder: scan.Folder})
+	}
+
+	return samples, nil
+}
+
+func diagnose(w io.Writer, explainer autoscan.Explainer, libraries []autoscan.Library, samples []sample) {
+	for _, o := range overlappingLibraries(libraries) {
+		fmt.Fprintf(w, "  ✗ libraries %q and %q overlap, scans within %s may be sent to both\n",
+			o[0].Name, o[1].Name, o[1].Path)
+	}
+
+	suggestions := make([]autoscan.Rewrite, 0)
+	suggested := make(map[autoscan.Rewrite]bool)
+
+	for _, s := range samples {
+		e := explainer.Explain(autoscan.Scan{Folder: s.Folder})
+		if len(e.Libraries) > 0 || (s.Root && containsLibrary(e.Folder, libraries)) {
+			continue
+		}
+
+		fmt.Fprintf(w, "  ✗ %s path %s is rewritten to %s, which does not match any library\n",
+			s.Source, s.Folder, e.Folder)
+
+		rewrite, ok := suggestRewrite(s.Folder, libraries)
+		if !ok || suggested[rewrite] {
+			continue
+		}
+
+		suggested[rewrite] = true
+		suggestions = append(suggestions, rewrite)
+	}
+
+	if len(suggestions) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "  suggested rewrite rules (place them above the existing rules of this target):")
+	fmt.Fprintln(w, "    rewrite:")
+	for _, r := range suggestions {
+		fmt.Fprintf(w, "      - from: %s\n        to: %s\n", r.From, r.To)
+	}
+}
+
+// overlappingLibraries returns the pairs of distinct libraries where
+// the path of the second library is located within the path of the first.
+func overlappingLibraries(libraries []autoscan.Library) [][2]autoscan.Library {
+	overlaps := make([][2]autoscan.Library, 0)
+
+	for i, a := range libraries {
+		for j, b := range libraries {
+			if i == j || (a.ID == b.ID && a.Name == b.Name) {
+				continue
+			}
+
+			if a.Path == b.Path && i > j {
+				// identical paths are only reported once
+				continue
+			}
+
+			if autoscan.ContainsPath(a.Path, b.Path) {
+				overlaps = append(overlaps, [2]autoscan.Library{a, b})
+			}
+		}
+	}
+
+	return overlaps
+}
+
+// containsLibrary checks whether a library is located within the folder.
+func containsLibrary(folder string, libraries []autoscan.Library) bool {
+	for _, lib := range libraries {
+		if autoscan.ContainsPath(folder, lib.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suggestRewrite proposes a rewrite rule translating the local folder into a library path.
+// The library sharing the most trailing path segments with the folder is used,
+// e.g. /mnt/unionfs/Media/TV/Westworld and /data/TV/ result in
+// the rule from ^/mnt/unionfs/Media/ to /data/.
+func suggestRewrite(folder string, libraries []autoscan.Library) (autoscan.Rewrite, bool) {
+	local := splitPath(folder)
+
+	best, bestLen := autoscan.Rewrite{}, 0
+	for _, lib := range libraries {
+		lp := splitPath(lib.Path)
+
+		for n := len(lp); n > bestLen; n-- {
+			idx := indexOfSegments(local, lp[len(lp)-n:])
+			if idx < 0 {
+				continue
+			}
+
+			best = autoscan.Rewrite{
+				From: "^" + regexp.QuoteMeta(joinPath(local[:idx])),
+				To:   joinPath(lp[:len(lp)-n]),
+			}
+			bestLen = n
+			break
+		}
+	}
+
+	return best, bestLen > 0
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+func joinPath(segments []string) string {
+	if len(segments) == 0 {
+		return "/"
+	}
+
+	return "/" + strings.Join(segments, "/") + "/"
+}
+
+func indexOfSegments(haystack []string, needle []string) int {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		match := true
+		for j := range needle {
+			if haystack[i+j] != needle[j] {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return i
+		}
+	}
+
+	return -1
+}
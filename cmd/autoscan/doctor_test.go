@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+func TestSuggestRewrite(t *testing.T) {
+	type Test struct {
+		Name      string
+		Folder    string
+		Libraries []autoscan.Library
+		Expected  autoscan.Rewrite
+		Found     bool
+	}
+
+	var testCases = []Test{
+		{
+			Name:      "Replaces the prefix before the library folder",
+			Folder:    "/mnt/unionfs/Media/TV/Westworld",
+			Libraries: []autoscan.Library{{Name: "TV", Path: "/data/TV/"}},
+			Expected:  autoscan.Rewrite{From: "^/mnt/unionfs/Media/", To: "/data/"},
+			Found:     true,
+		},
+		{
+			Name:   "Prefers the library sharing the most folders",
+			Folder: "/mnt/unionfs/Media/TV/Kids/Bluey",
+			Libraries: []autoscan.Library{
+				{Name: "TV", Path: "/data/TV/"},
+				{Name: "Kids", Path: "/data/TV/Kids/"},
+			},
+			Expected: autoscan.Rewrite{From: "^/mnt/unionfs/Media/", To: "/data/"},
+			Found:    true,
+		},
+		{
+			Name:      "Rewrites to the root",
+			Folder:    "/mnt/unionfs/Movies/Interstellar (2014)",
+			Libraries: []autoscan.Library{{Name: "Movies", Path: "/Movies/"}},
+			Expected:  autoscan.Rewrite{From: "^/mnt/unionfs/", To: "/"},
+			Found:     true,
+		},
+		{
+			Name:      "Quotes the local prefix",
+			Folder:    "/mnt/media (remote)/TV/Westworld",
+			Libraries: []autoscan.Library{{Name: "TV", Path: "/data/TV/"}},
+			Expected:  autoscan.Rewrite{From: `^/mnt/media \(remote\)/`, To: "/data/"},
+			Found:     true,
+		},
+		{
+			Name:      "Does not suggest a rule without shared folders",
+			Folder:    "/mnt/unionfs/Media/Music/Queen",
+			Libraries: []autoscan.Library{{Name: "TV", Path: "/data/TV/"}},
+			Found:     false,
+		},
+		{
+			Name:   "Does not suggest a rule without libraries",
+			Folder: "/mnt/unionfs/Media/TV/Westworld",
+			Found:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rewrite, found := suggestRewrite(tc.Folder, tc.Libraries)
+			if found != tc.Found {
+				t.Fatalf("Found does not match: %v vs %v (expected)", found, tc.Found)
+			}
+
+			if rewrite != tc.Expected {
+				t.Errorf("Rewrites do not match: %v vs %v (expected)", rewrite, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestOverlappingLibraries(t *testing.T) {
+	type Test struct {
+		Name      string
+		Libraries []autoscan.Library
+		Expected  [][2]string
+	}
+
+	var testCases = []Test{
+		{
+			Name: "Reports a library within another",
+			Libraries: []autoscan.Library{
+				{ID: "1", Name: "TV", Path: "/data/TV/"},
+				{ID: "2", Name: "Kids", Path: "/data/TV/Kids/"},
+			},
+			Expected: [][2]string{{"TV", "Kids"}},
+		},
+		{
+			Name: "Does not report libraries sharing a prefix",
+			Libraries: []autoscan.Library{
+				{ID: "1", Name: "TV", Path: "/data/TV/"},
+				{ID: "2", Name: "TV 4K", Path: "/data/TV 4K/"},
+			},
+			Expected: [][2]string{},
+		},
+		{
+			Name: "Reports libraries with the same path once",
+			Libraries: []autoscan.Library{
+				{ID: "1", Name: "Movies", Path: "/data/Movies/"},
+				{ID: "2", Name: "Films", Path: "/data/Movies/"},
+			},
+			Expected: [][2]string{{"Movies", "Films"}},
+		},
+		{
+			Name: "Does not report the folders of a single library",
+			Libraries: []autoscan.Library{
+				{ID: "1", Name: "TV", Path: "/data/TV/"},
+				{ID: "1", Name: "TV", Path: "/data/TV/Kids/"},
+			},
+			Expected: [][2]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			overlaps := make([][2]string, 0)
+			for _, o := range overlappingLibraries(tc.Libraries) {
+				overlaps = append(overlaps, [2]string{o[0].Name, o[1].Name})
+			}
+
+			if !reflect.DeepEqual(overlaps, tc.Expected) {
+				t.Errorf("Overlaps do not match: %v vs %v (expected)", overlaps, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestIndexOfSegments(t *testing.T) {
+	type Test struct {
+		Name     string
+		Haystack []string
+		Needle   []string
+		Expected int
+	}
+
+	var testCases = []Test{
+		{"Finds the needle", []string{"mnt", "Media", "TV", "Westworld"}, []string{"TV", "Westworld"}, 2},
+		{"Finds the first occurrence", []string{"TV", "TV", "TV"}, []string{"TV"}, 0},
+		{"Finds an empty needle", []string{"TV"}, nil, 0},
+		{"Requires consecutive segments", []string{"mnt", "TV", "Media", "Westworld"}, []string{"TV", "Westworld"}, -1},
+		{"Does not find a longer needle", []string{"TV"}, []string{"TV", "Westworld"}, -1},
+		{"Does not match partial segments", []string{"mnt", "TV 4K"}, []string{"TV"}, -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if idx := indexOfSegments(tc.Haystack, tc.Needle); idx != tc.Expected {
+				t.Errorf("Indexes do not match: %d vs %d (expected)", idx, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	type Test struct {
+		Path     string
+		Segments []string
+		Joined   string
+	}
+
+	var testCases = []Test{
+		{"/data/TV/", []string{"data", "TV"}, "/data/TV/"},
+		{"/data/TV", []string{"data", "TV"}, "/data/TV/"},
+		{"data/TV", []string{"data", "TV"}, "/data/TV/"},
+		{"/", nil, "/"},
+		{"", nil, "/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Path, func(t *testing.T) {
+			segments := splitPath(tc.Path)
+			if !reflect.DeepEqual(segments, tc.Segments) {
+				t.Errorf("Segments do not match: %q vs %q (expected)", segments, tc.Segments)
+			}
+
+			if joined := joinPath(segments); joined != tc.Joined {
+				t.Errorf("Paths do not match: %q vs %q (expected)", joined, tc.Joined)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudbox/autoscan"
+	"github.com/cloudbox/autoscan/processor"
+)
+
+type explainCmd struct {
+	Path    string `arg:"" optional:"" help:"Path to trace through the triggers"`
+	Trigger string `help:"Trigger route to send the webhook body to, e.g. sonarr or a-train/{drive}"`
+	Body    string `type:"path" help:"File containing a sample webhook body"`
+}
+
+func (cmd explainCmd) run(c config, proc *processor.Processor) error {
+	ex := &explainer{
+		proc:    proc,
+		targets: getTargets(c),
+	}
+
+	if cmd.Trigger == "" {
+		if cmd.Path == "" {
+			return errors.New("either a path or a trigger must be given")
+		}
+
+		e, err := ex.explainPath(c, cmd.Path)
+		if err != nil {
+			return err
+		}
+
+		return writeExplanation(os.Stdout, e)
+	}
+
+	// send the webhook body through the trigger routes in explain mode
+	r := chi.NewRouter()
+	r.Route("/triggers", func(r chi.Router) {
+		triggerRoutes(r, c, proc.Add, ex)
+	})
+
+	u, err := url.Parse(autoscan.JoinURL("/triggers", cmd.Trigger))
+	if err != nil {
+		return fmt.Errorf("trigger: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("explain", "1")
+	u.RawQuery = q.Encode()
+
+	var body io.Reader = http.NoBody
+	if cmd.Body != "" {
+		f, err := os.Open(cmd.Body)
+		if err != nil {
+			return fmt.Errorf("body: %w", err)
+		}
+		defer f.Close()
+
+		body = f
+	}
+
+	req := httptest.NewRequest("POST", u.String(), body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("%v: unexpected status code: %d", u.Path, rec.Code)
+	}
+
+	_, err = io.Copy(os.Stdout, rec.Body)
+	return err
+}
+
+type explainer struct {
+	proc    *processor.Processor
+	targets []autoscan.Target
+}
+
+type explanation struct {
+	Status    int                   `json:"status,omitempty"`
+	Triggers  []triggerExplanation  `json:"triggers,omitempty"`
+	Scans     []autoscan.Scan       `json:"scans"`
+	Processor processor.Explanation `json:"processor"`
+	Targets   []targetExplanation   `json:"targets"`
+}
+
+type triggerExplanation struct {
+	Trigger string `json:"trigger"`
+	Name    string `json:"name,omitempty"`
+	Folder  string `json:"folder"`
+	Allowed bool   `json:"allowed"`
+
+	scan autoscan.Scan
+}
+
+type targetExplanation struct {
+	Folder  string                 `json:"folder"`
+	Results []autoscan.Explanation `json:"results"`
+}
+
+// explain describes what the processor and all targets would do with the scans.
+func (ex *explainer) explain(scans []autoscan.Scan) explanation {
+	e := explanation{
+		Scans:     scans,
+		Processor: ex.proc.Explain(scans...),
+		Targets:   make([]targetExplanation, 0),
+	}
+
+	for _, se := range e.Processor.Scans {
+		if se.Queued == "" {
+			continue
+		}
+
+		te := targetExplanation{
+			Folder:  se.Queued,
+			Results: make([]autoscan.Explanation, 0, len(ex.targets)),
+		}
+
+		for _, t := range ex.targets {
+			explainer, ok := t.(autoscan.Explainer)
+			if !ok {
+				te.Results = append(te.Results, autoscan.Explanation{
+					Target: fmt.Sprintf("%T", t),
+					Error:  "target does not support explain",
+				})
+				continue
+			}
+
+			te.Results = append(te.Results, explainer.Explain(autoscan.Scan{Folder: se.Queued, File: se.File, Event: se.Event, Priority: se.Priority}))
+		}
+
+		e.Targets = append(e.Targets, te)
+	}
+
+	return e
+}
+
+// explainPath runs the path through the rewriters and filterers of all triggers
+// and explains the resulting scans.
+func (ex *explainer) explainPath(c config, input string) (explanation, error) {
+	triggers := make([]triggerExplanation, 0)
+
+	add := func(trigger string, name string, rules []autoscan.Rewrite, includes []string, excludes []string, withPath bool) error {
+		rewriter, err := autoscan.NewRewriter(rules)
+		if err != nil {
+			return fmt.Errorf("%v: %w", trigger, err)
+		}
+
+		filterer, err := autoscan.NewFilterer(includes, excludes)
+		if err != nil {
+			return fmt.Errorf("%v: %w", trigger, err)
+		}
+
+		te := triggerExplanation{
+			Trigger: trigger,
+			Name:    name,
+			Folder:  rewriter(input),
+		}
+		te.Allowed = filterer(te.Folder)
+		te.scan = autoscan.Scan{Folder: path.Clean(te.Folder)}
+		if withPath {
+			te.scan.Path = input
+		}
+
+		triggers = append(triggers, te)
+		return nil
+	}
+
+	// the manual trigger is always enabled
+	if err := add("manual", "", c.Triggers.Manual.Rewrite, nil, nil, false); err != nil {
+		return explanation{}, err
+	}
+
+	if err := add("a-train", "", c.Triggers.ATrain.Rewrite, nil, nil, true); err != nil {
+		return explanation{}, err
+	}
+
+	for _, d := range c.Triggers.ATrain.Drives {
+		if err := add("a-train", d.ID, concat(d.Rewrite, c.Triggers.ATrain.Rewrite), nil, nil, true); err != nil {
+			return explanation{}, err
+		}
+	}
+
+	for _, t := range c.Triggers.Bernard {
+		for _, d := range t.Drives {
+			err := add("bernard", d.ID, concat(d.Rewrite, t.Rewrite),
+				concat(d.Include, t.Include), concat(d.Exclude, t.Exclude), true)
+			if err != nil {
+				return explanation{}, err
+			}
+		}
+	}
+
+	for _, t := range c.Triggers.Inotify {
+		for _, p := range t.Paths {
+			if !autoscan.ContainsPath(p.Path, input) {
+				continue
+			}
+
+			err := add("inotify", p.Path, concat(p.Rewrite, t.Rewrite),
+				concat(p.Include, t.Include), concat(p.Exclude, t.Exclude), false)
+			if err != nil {
+				return explanation{}, err
+			}
+		}
+	}
+
+	for _, t := range c.Triggers.Lidarr {
+		if err := add("lidarr", t.Name, t.Rewrite, nil, nil, false); err != nil {
+			return explanation{}, err
+		}
+	}
+
+	for _, t := range c.Triggers.Radarr {
+		if err := add("radarr", t.Name, t.Rewrite, nil, nil, false); err != nil {
+			return explanation{}, err
+		}
+	}
+
+	for _, t := range c.Triggers.Readarr {
+		if err := add("readarr", t.Name, t.Rewrite, nil, nil, false); err != nil {
+			return explanation{}, err
+		}
+	}
+
+	for _, t := range c.Triggers.Sonarr {
+		if err := add("sonarr", t.Name, t.Rewrite, nil, nil, false); err != nil {
+			return explanation{}, err
+		}
+	}
+
+	// explain every unique scan which passed the filters
+	unique := make(map[autoscan.Scan]bool)
+	scans := make([]autoscan.Scan, 0)
+	for _, te := range triggers {
+		if !te.Allowed || unique[te.scan] {
+			continue
+		}
+
+		unique[te.scan] = true
+		scans = append(scans, te.scan)
+	}
+
+	e := ex.explain(scans)
+	e.Triggers = triggers
+	return e, nil
+}
+
+// handler returns the http.HandlerFunc of the trigger.
+// Requests with the explain query parameter are not moved to the processor,
+// instead the handler responds with the explanation of the scans.
+func (ex *explainer) handler(trigger autoscan.HTTPTrigger, callback autoscan.ProcessorFunc) http.HandlerFunc {
+	h := trigger(callback)
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if explain, _ := strconv.ParseBool(r.URL.Query().Get("explain")); !explain {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		scans := make([]autoscan.Scan, 0)
+		rec := httptest.NewRecorder()
+		trigger(func(s ...autoscan.Scan) error {
+			scans = append(scans, s...)
+			return nil
+		}).ServeHTTP(rec, r)
+
+		e := ex.explain(scans)
+		e.Status = rec.Code
+
+		rw.Header().Set("Content-Type", "application/json")
+		if err := writeExplanation(rw, e); err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+func writeExplanation(w io.Writer, e explanation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// concat returns a new slice holding the elements of both slices,
+// so the config slices are never appended to.
+func concat[T any](a []T, b []T) []T {
+	return append(append(make([]T, 0, len(a)+len(b)), a...), b...)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/cloudbox/autoscan"
+	"github.com/cloudbox/autoscan/processor"
+)
+
+type idempotencyConfig struct {
+	// Window is the time during which a repeated delivery is acknowledged
+	// without being processed again. Deduplication is disabled when not set.
+	Window time.Duration `yaml:"window"`
+}
+
+// deliveries stores the keys of completed deliveries, it is implemented by the processor.
+type deliveries interface {
+	DeliveryCompleted(key string, window time.Duration) (bool, error)
+	CompleteDelivery(key string) error
+}
+
+var _ deliveries = (*processor.Processor)(nil)
+
+// pendingRetryAfter is the number of seconds a client is asked to wait
+// before retrying a delivery which is still being processed.
+const pendingRetryAfter = 1
+
+type deduplicator struct {
+	store  deliveries
+	window time.Duration
+
+	// pending holds the keys of the deliveries which are being processed.
+	pending map[string]bool
+	mu      sync.Mutex
+}
+
+// newDeduplicator returns nil when deduplication is disabled.
+func newDeduplicator(c idempotencyConfig, store deliveries) *deduplicator {
+	if c.Window <= 0 {
+		return nil
+	}
+
+	return &deduplicator{
+		store:   store,
+		window:  c.Window,
+		pending: make(map[string]bool),
+	}
+}
+
+// claim marks the delivery as pending, unless it already is.
+func (d *deduplicator) claim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.pending[key] {
+		return false
+	}
+
+	d.pending[key] = true
+	return true
+}
+
+func (d *deduplicator) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, key)
+}
+
+// deliveryKey returns the idempotency key of a webhook delivery.
+// The Idempotency-Key header is used when given, otherwise the key is a hash of the request body.
+// Keys are scoped to the route of the trigger.
+// Requests without a body or with a body larger than the maximum body size do not have a key.
+func deliveryKey(r *http.Request) (string, bool) {
+	h := sha256.New()
+	h.Write([]byte(r.URL.Path + "\x00"))
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		h.Write([]byte("header\x00" + key))
+		return hex.EncodeToString(h.Sum(nil)), true
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", false
+	}
+
+	// restore the body for the trigger, including anything which has not been read.
+	body, err := io.ReadAll(io.LimitReader(r.Body, autoscan.DefaultMaxBodySize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	if err != nil || len(body) == 0 || int64(len(body)) > autoscan.DefaultMaxBodySize {
+		return "", false
+	}
+
+	h.Write([]byte("body\x00"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Middleware acknowledges repeated deliveries of a webhook within the window
+// without passing them to the trigger.
+// Deliveries are only remembered when the trigger succeeds, so retries of failed deliveries are processed.
+// Repeated deliveries which arrive while the first is still being processed receive a 409 with a Retry-After header.
+func (d *deduplicator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// explain mode does not create any work
+		if explain, _ := strconv.ParseBool(r.URL.Query().Get("explain")); explain {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		key, ok := deliveryKey(r)
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		rlog := hlog.FromRequest(r)
+		rlog.UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("delivery", key[:16])
+		})
+
+		if !d.claim(key) {
+			rlog.Info().Msg("Delivery is still being processed")
+			rw.Header().Set("Retry-After", strconv.Itoa(pendingRetryAfter))
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		defer d.release(key)
+
+		completed, err := d.store.DeliveryCompleted(key, d.window)
+		if err != nil {
+			rlog.Error().Err(err).Msg("Failed checking delivery for duplicates")
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if completed {
+			rlog.Info().Msg("Duplicate delivery, skipping")
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// handlers which do not write a status respond with 200 OK
+		if status := ww.Status(); status != 0 && (status < 200 || status >= 300) {
+			return
+		}
+
+		if err := d.store.CompleteDelivery(key); err != nil {
+			rlog.Error().Err(err).Msg("Failed storing delivery")
+		}
+	})
+}
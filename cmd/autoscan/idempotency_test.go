@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDeliveries struct {
+	mu        sync.Mutex
+	completed map[string]bool
+}
+
+func (f *fakeDeliveries) DeliveryCompleted(key string, _ time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.completed[key], nil
+}
+
+func (f *fakeDeliveries) CompleteDelivery(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.completed[key] = true
+	return nil
+}
+
+func TestDeduplicator(t *testing.T) {
+	type Delivery struct {
+		Path   string
+		Body   string
+		Key    string
+		Status int // status of the trigger
+	}
+
+	type Test struct {
+		Name       string
+		Deliveries []Delivery
+		Expected   []int
+		Calls      int
+	}
+
+	var testCases = []Test{
+		{
+			Name: "Acknowledges repeated deliveries without processing them",
+			Deliveries: []Delivery{
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`},
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`},
+			},
+			Expected: []int{http.StatusOK, http.StatusOK},
+			Calls:    1,
+		},
+		{
+			Name: "Processes retries of failed deliveries",
+			Deliveries: []Delivery{
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`, Status: http.StatusInternalServerError},
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`},
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`},
+			},
+			Expected: []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+			Calls:    2,
+		},
+		{
+			Name: "Scopes deliveries to their trigger",
+			Deliveries: []Delivery{
+				{Path: "/triggers/sonarr", Body: `{"eventType":"Download"}`},
+				{Path: "/triggers/sonarr4k", Body: `{"eventType":"Download"}`},
+			},
+			Expected: []int{http.StatusOK, http.StatusOK},
+			Calls:    2,
+		},
+		{
+			Name: "Identifies deliveries by their Idempotency-Key header",
+			Deliveries: []Delivery{
+				{Path: "/triggers/manual", Key: "1"},
+				{Path: "/triggers/manual", Key: "1"},
+				{Path: "/triggers/manual", Key: "2"},
+			},
+			Expected: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			Calls:    2,
+		},
+		{
+			Name: "Processes every delivery without a key",
+			Deliveries: []Delivery{
+				{Path: "/triggers/manual"},
+				{Path: "/triggers/manual"},
+			},
+			Expected: []int{http.StatusOK, http.StatusOK},
+			Calls:    2,
+		},
+		{
+			Name: "Processes every delivery in explain mode",
+			Deliveries: []Delivery{
+				{Path: "/triggers/sonarr?explain=true", Body: `{"eventType":"Download"}`},
+				{Path: "/triggers/sonarr?explain=true", Body: `{"eventType":"Download"}`},
+			},
+			Expected: []int{http.StatusOK, http.StatusOK},
+			Calls:    2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			d := newDeduplicator(idempotencyConfig{Window: time.Hour}, &fakeDeliveries{completed: make(map[string]bool)})
+
+			calls := 0
+			status := 0
+			handler := d.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				calls++
+				if status != 0 {
+					rw.WriteHeader(status)
+				}
+			}))
+
+			for i, delivery := range tc.Deliveries {
+				req := httptest.NewRequest("POST", delivery.Path, strings.NewReader(delivery.Body))
+				if delivery.Body == "" {
+					req.Body = http.NoBody
+				}
+
+				if delivery.Key != "" {
+					req.Header.Set("Idempotency-Key", delivery.Key)
+				}
+
+				status = delivery.Status
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				if rr.Code != tc.Expected[i] {
+					t.Errorf("Status codes of delivery %d do not match: %d vs %d (expected)", i, rr.Code, tc.Expected[i])
+				}
+			}
+
+			if calls != tc.Calls {
+				t.Errorf("Calls do not match: %d vs %d (expected)", calls, tc.Calls)
+			}
+		})
+	}
+}
+
+func TestDeduplicatorPending(t *testing.T) {
+	d := newDeduplicator(idempotencyConfig{Window: time.Hour}, &fakeDeliveries{completed: make(map[string]bool)})
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := d.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+	}))
+
+	deliver := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/triggers/sonarr", strings.NewReader(`{"eventType":"Download"}`))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- deliver()
+	}()
+
+	<-started
+	rr := deliver()
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, http.StatusConflict)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	close(finish)
+	if rr := <-done; rr.Code != http.StatusOK {
+		t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, http.StatusOK)
+	}
+
+	// the completed delivery is acknowledged without calling the trigger again
+	if rr := deliver(); rr.Code != http.StatusOK {
+		t.Errorf("Status codes do not match: %d vs %d (expected)", rr.Code, http.StatusOK)
+	}
+}
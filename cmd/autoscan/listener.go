@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// routeTriggers serves the webhooks of the HTTP triggers under /triggers.
+	routeTriggers = "triggers"
+	// routeAdmin serves the API under /api.
+	routeAdmin = "admin"
+)
+
+type listenerConfig struct {
+	// Address is a host:port combination to listen on with TCP.
+	Address string `yaml:"address"`
+
+	// Socket is the path of a unix domain socket to listen on.
+	Socket     string `yaml:"socket"`
+	SocketMode uint32 `yaml:"socket-mode"`
+
+	// Routes limits the routes served by the listener, defaults to all routes.
+	Routes []string `yaml:"routes"`
+
+	TLS struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+}
+
+func (l listenerConfig) String() string {
+	if l.Socket != "" {
+		return "unix:" + l.Socket
+	}
+
+	return l.Address
+}
+
+// validate checks the listener, authenticated is whether credentials are configured.
+func (l listenerConfig) validate(authenticated bool) error {
+	if (l.Address == "") == (l.Socket == "") {
+		return errors.New("either an address or a socket must be given")
+	}
+
+	if (l.TLS.Cert == "") != (l.TLS.Key == "") {
+		return errors.New("tls requires both a cert and a key")
+	}
+
+	for _, route := range l.Routes {
+		if route != routeTriggers && route != routeAdmin {
+			return fmt.Errorf("unknown route: %v", route)
+		}
+
+		if route == routeAdmin && !authenticated && !l.local() {
+			return errors.New("the admin route requires credentials on listeners which are reachable from other hosts")
+		}
+	}
+
+	return nil
+}
+
+// local returns whether the listener can only be reached from the same host.
+func (l listenerConfig) local() bool {
+	if l.Socket != "" {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serves returns whether the listener serves the route, authenticated is whether credentials are configured.
+// Without routes, the listener serves all routes, except for the admin route
+// when it is reachable from other hosts without credentials.
+func (l listenerConfig) serves(route string, authenticated bool) bool {
+	if len(l.Routes) == 0 {
+		return route != routeAdmin || authenticated || l.local()
+	}
+
+	for _, r := range l.Routes {
+		if r == route {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getListeners returns the configured listeners.
+// The host and port fields are used when no listeners are configured.
+func getListeners(c config) []listenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+
+	listeners := make([]listenerConfig, 0, len(c.Host))
+	for _, host := range c.Host {
+		addr := host
+		if !strings.Contains(addr, ":") {
+			addr = fmt.Sprintf("%s:%d", host, c.Port)
+		}
+
+		listeners = append(listeners, listenerConfig{Address: addr})
+	}
+
+	return listeners
+}
+
+func (l listenerConfig) listen() (net.Listener, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+
+	if l.Socket != "" {
+		// remove a stale socket from a previous run
+		if fi, err := os.Stat(l.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(l.Socket); err != nil {
+				return nil, fmt.Errorf("remove stale socket: %w", err)
+			}
+		}
+
+		ln, err = net.Listen("unix", l.Socket)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.SocketMode != 0 {
+			if err := os.Chmod(l.Socket, os.FileMode(l.SocketMode)); err != nil {
+				ln.Close()
+				return nil, fmt.Errorf("chmod socket: %w", err)
+			}
+		}
+	} else {
+		ln, err = net.Listen("tcp", l.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.TLS.Cert == "" {
+		return ln, nil
+	}
+
+	reloader, err := newCertReloader(l.TLS.Cert, l.TLS.Key)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(ln, &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}), nil
+}
+
+func serve(l listenerConfig, handler http.Handler) {
+	ln, err := l.listen()
+	if err != nil {
+		log.Fatal().
+			Stringer("addr", l).
+			Err(err).
+			Msg("Failed starting web server")
+	}
+
+	log.Info().
+		Strs("routes", l.Routes).
+		Bool("tls", l.TLS.Cert != "").
+		Msgf("Starting server on %s", l)
+
+	if err := http.Serve(ln, handler); err != nil {
+		log.Fatal().
+			Stringer("addr", l).
+			Err(err).
+			Msg("Failed starting web server")
+	}
+}
+
+// certReloadInterval is the minimum time between checks for changed certificates.
+const certReloadInterval = 10 * time.Second
+
+// A certReloader reloads the certificate when the cert or key file changes on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < certReloadInterval {
+		return r.cert, nil
+	}
+
+	r.lastCheck = time.Now()
+	modTime, err := r.filesModTime()
+	if err != nil || !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	// keep serving the previous certificate when the new one is invalid,
+	// e.g. when only the cert has been written so far.
+	if err := r.reload(); err != nil {
+		log.Error().
+			Err(err).
+			Str("cert", r.certFile).
+			Msg("Failed reloading certificate")
+
+		return r.cert, nil
+	}
+
+	log.Info().
+		Str("cert", r.certFile).
+		Msg("Reloaded certificate")
+
+	return r.cert, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenerValidate(t *testing.T) {
+	type Test struct {
+		Name          string
+		Listener      listenerConfig
+		Authenticated bool
+		Valid         bool
+	}
+
+	withTLS := func(l listenerConfig, cert string, key string) listenerConfig {
+		l.TLS.Cert = cert
+		l.TLS.Key = key
+		return l
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Accepts an address",
+			Listener: listenerConfig{Address: "0.0.0.0:3030"},
+			Valid:    true,
+		},
+		{
+			Name:     "Accepts a socket",
+			Listener: listenerConfig{Socket: "/run/autoscan.sock", Routes: []string{routeAdmin}},
+			Valid:    true,
+		},
+		{
+			Name:     "Rejects a listener without an address or socket",
+			Listener: listenerConfig{},
+			Valid:    false,
+		},
+		{
+			Name:     "Rejects a listener with an address and a socket",
+			Listener: listenerConfig{Address: "0.0.0.0:3030", Socket: "/run/autoscan.sock"},
+			Valid:    false,
+		},
+		{
+			Name:     "Accepts TLS with a cert and key",
+			Listener: withTLS(listenerConfig{Address: "0.0.0.0:3030"}, "cert.pem", "key.pem"),
+			Valid:    true,
+		},
+		{
+			Name:     "Rejects TLS without a key",
+			Listener: withTLS(listenerConfig{Address: "0.0.0.0:3030"}, "cert.pem", ""),
+			Valid:    false,
+		},
+		{
+			Name:     "Rejects unknown routes",
+			Listener: listenerConfig{Address: "0.0.0.0:3030", Routes: []string{"api"}},
+			Valid:    false,
+		},
+		{
+			Name:     "Accepts the admin route on a loopback address without credentials",
+			Listener: listenerConfig{Address: "127.0.0.1:3030", Routes: []string{routeAdmin}},
+			Valid:    true,
+		},
+		{
+			Name:     "Rejects the admin route on other addresses without credentials",
+			Listener: listenerConfig{Address: "0.0.0.0:3030", Routes: []string{routeAdmin}},
+			Valid:    false,
+		},
+		{
+			Name:          "Accepts the admin route on other addresses with credentials",
+			Listener:      listenerConfig{Address: "0.0.0.0:3030", Routes: []string{routeAdmin}},
+			Authenticated: true,
+			Valid:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Listener.validate(tc.Authenticated)
+			if (err == nil) != tc.Valid {
+				t.Errorf("Unexpected validation result: %v", err)
+			}
+		})
+	}
+}
+
+func TestListenerServes(t *testing.T) {
+	type Test struct {
+		Name          string
+		Listener      listenerConfig
+		Authenticated bool
+		Triggers      bool
+		Admin         bool
+	}
+
+	var testCases = []Test{
+		{
+			Name:          "Serves all routes by default with credentials",
+			Listener:      listenerConfig{Address: ":3030"},
+			Authenticated: true,
+			Triggers:      true,
+			Admin:         true,
+		},
+		{
+			Name:     "Serves the admin route by default on a socket",
+			Listener: listenerConfig{Socket: "/run/autoscan.sock"},
+			Triggers: true,
+			Admin:    true,
+		},
+		{
+			Name:     "Serves the admin route by default on localhost",
+			Listener: listenerConfig{Address: "localhost:3030"},
+			Triggers: true,
+			Admin:    true,
+		},
+		{
+			Name:     "Serves the admin route by default on an IPv6 loopback address",
+			Listener: listenerConfig{Address: "[::1]:3030"},
+			Triggers: true,
+			Admin:    true,
+		},
+		{
+			Name:     "Does not serve the admin route by default on other addresses without credentials",
+			Listener: listenerConfig{Address: ":3030"},
+			Triggers: true,
+			Admin:    false,
+		},
+		{
+			Name:          "Only serves the given routes",
+			Listener:      listenerConfig{Address: ":3030", Routes: []string{routeTriggers}},
+			Authenticated: true,
+			Triggers:      true,
+			Admin:         false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := tc.Listener.serves(routeTriggers, tc.Authenticated); got != tc.Triggers {
+				t.Errorf("Triggers do not match: %v vs %v (expected)", got, tc.Triggers)
+			}
+
+			if got := tc.Listener.serves(routeAdmin, tc.Authenticated); got != tc.Admin {
+				t.Errorf("Admin does not match: %v vs %v (expected)", got, tc.Admin)
+			}
+		})
+	}
+}
+
+// writeCert writes a self-signed certificate with the serial number and its key.
+func writeCert(t *testing.T, certFile string, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "autoscan"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	serial := func(cert *tls.Certificate) int64 {
+		t.Helper()
+
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed.SerialNumber.Int64()
+	}
+
+	// touch moves the modification time of the files forward
+	// and allows the reloader to check them again.
+	touch := func(r *certReloader, d time.Duration) {
+		t.Helper()
+
+		modTime := time.Now().Add(d)
+		for _, f := range []string{certFile, keyFile} {
+			if err := os.Chtimes(f, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r.mu.Lock()
+		r.lastCheck = time.Time{}
+		r.mu.Unlock()
+	}
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Fatal("Expected missing files to fail")
+	}
+
+	writeCert(t, certFile, keyFile, 1)
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() int64 {
+		t.Helper()
+
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return serial(cert)
+	}
+
+	if got := get(); got != 1 {
+		t.Errorf("Serials do not match: %d vs %d (expected)", got, 1)
+	}
+
+	// changes are not picked up within the reload interval
+	writeCert(t, certFile, keyFile, 2)
+	if got := get(); got != 1 {
+		t.Errorf("Serials do not match: %d vs %d (expected)", got, 1)
+	}
+
+	touch(r, time.Minute)
+	if got := get(); got != 2 {
+		t.Errorf("Serials do not match: %d vs %d (expected)", got, 2)
+	}
+
+	// an invalid certificate keeps the previous one
+	if err := os.WriteFile(certFile, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	touch(r, 2*time.Minute)
+	if got := get(); got != 2 {
+		t.Errorf("Serials do not match: %d vs %d (expected)", got, 2)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,9 +20,15 @@ import (
 	"github.com/cloudbox/autoscan/migrate"
 	"github.com/cloudbox/autoscan/processor"
 	ast "github.com/cloudbox/autoscan/targets/autoscan"
+	"github.com/cloudbox/autoscan/targets/command"
 	"github.com/cloudbox/autoscan/targets/emby"
 	"github.com/cloudbox/autoscan/targets/jellyfin"
+	"github.com/cloudbox/autoscan/targets/kavita"
+	"github.com/cloudbox/autoscan/targets/kodi"
+	"github.com/cloudbox/autoscan/targets/komga"
 	"github.com/cloudbox/autoscan/targets/plex"
+	"github.com/cloudbox/autoscan/targets/subsonic"
+	"github.com/cloudbox/autoscan/targets/webhook"
 	"github.com/cloudbox/autoscan/triggers/a_train"
 	"github.com/cloudbox/autoscan/triggers/bernard"
 	"github.com/cloudbox/autoscan/triggers/inotify"
@@ -39,18 +44,43 @@ import (
 
 type config struct {
 	// General configuration
-	Host       []string      `yaml:"host"`
-	Port       int           `yaml:"port"`
-	MinimumAge time.Duration `yaml:"minimum-age"`
-	ScanDelay  time.Duration `yaml:"scan-delay"`
-	ScanStats  time.Duration `yaml:"scan-stats"`
-	Anchors    []string      `yaml:"anchors"`
-
-	// Authentication for autoscan.HTTPTrigger
+	Host       []string         `yaml:"host"`
+	Port       int              `yaml:"port"`
+	Listeners  []listenerConfig `yaml:"listeners"`
+	MinimumAge time.Duration    `yaml:"minimum-age"`
+	ScanDelay  time.Duration    `yaml:"scan-delay"`
+	ScanStats  time.Duration    `yaml:"scan-stats"`
+	Anchors    []string         `yaml:"anchors"`
+	DryRun     bool             `yaml:"dry-run"`
+	FileScans  bool             `yaml:"file-scans"`
+
+	// DeletePriority overrides the priority of deletions when set
+	DeletePriority *int `yaml:"delete-priority"`
+
+	// BatchSize is the maximum number of scans sent to a target at once
+	BatchSize int `yaml:"batch-size"`
+
+	// EscalateThreshold is the number of scans within a library at which the library is refreshed instead
+	EscalateThreshold int `yaml:"escalate-threshold"`
+
+	// Limits of the processor queue
+	Queue processor.QueueConfig `yaml:"queue"`
+
+	// Verification of processed scans
+	Verify processor.VerifyConfig `yaml:"verify"`
+
+	// Authentication for autoscan.HTTPTrigger and the admin API
 	Auth struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
 	} `yaml:"authentication"`
+	Credentials []credential `yaml:"credentials"`
+
+	// Access control for autoscan.HTTPTrigger
+	Access accessConfig `yaml:"access"`
+
+	// Deduplication of webhook deliveries
+	Idempotency idempotencyConfig `yaml:"idempotency"`
 
 	// autoscan.HTTPTrigger
 	Triggers struct {
@@ -68,8 +98,14 @@ type config struct {
 	Targets struct {
 		Autoscan []ast.Config      `yaml:"autoscan"`
 		Emby     []emby.Config     `yaml:"emby"`
+		Exec     []command.Config  `yaml:"exec"`
 		Jellyfin []jellyfin.Config `yaml:"jellyfin"`
+		Kavita   []kavita.Config   `yaml:"kavita"`
+		Kodi     []kodi.Config     `yaml:"kodi"`
+		Komga    []komga.Config    `yaml:"komga"`
 		Plex     []plex.Config     `yaml:"plex"`
+		Subsonic []subsonic.Config `yaml:"subsonic"`
+		Webhook  []webhook.Config  `yaml:"webhook"`
 	} `yaml:"targets"`
 }
 
@@ -88,6 +124,11 @@ var (
 		Database  string `type:"path" default:"${database_file}" env:"AUTOSCAN_DATABASE" help:"Database file path"`
 		Log       string `type:"path" default:"${log_file}" env:"AUTOSCAN_LOG" help:"Log file path"`
 		Verbosity int    `type:"counter" default:"0" short:"v" env:"AUTOSCAN_VERBOSITY" help:"Log level verbosity"`
+
+		// commands
+		Run     struct{}   `cmd:"" default:"1" help:"Run autoscan (default)"`
+		Explain explainCmd `cmd:"" help:"Trace a path or webhook body through triggers, processor and targets"`
+		Doctor  doctorCmd  `cmd:"" help:"Check the libraries of all targets and suggest rewrite rules"`
 	}
 )
 
@@ -162,7 +203,31 @@ func main() {
 	db.SetMaxOpenConns(1)
 
 	// config
-	file, err := os.Open(cli.Config)
+	c := loadConfig(cli.Config)
+
+	// processor
+	proc := getProcessor(c, db)
+
+	switch {
+	case strings.HasPrefix(ctx.Command(), "explain"):
+		if err := cli.Explain.run(c, proc); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed explaining")
+		}
+	case ctx.Command() == "doctor":
+		if err := cli.Doctor.run(c, proc); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed running doctor")
+		}
+	default:
+		run(c, db, proc)
+	}
+}
+
+func loadConfig(path string) config {
+	file, err := os.Open(path)
 	if err != nil {
 		log.Fatal().
 			Err(err).
@@ -188,6 +253,10 @@ func main() {
 			Msg("Failed decoding config")
 	}
 
+	return c
+}
+
+func getProcessor(c config, db *sql.DB) *processor.Processor {
 	// migrator
 	mg, err := migrate.New(db, "migrations")
 	if err != nil {
@@ -198,10 +267,16 @@ func main() {
 
 	// processor
 	proc, err := processor.New(processor.Config{
-		Anchors:    c.Anchors,
-		MinimumAge: c.MinimumAge,
-		Db:         db,
-		Mg:         mg,
+		Anchors:           c.Anchors,
+		MinimumAge:        c.MinimumAge,
+		Queue:             c.Queue,
+		FileScans:         c.FileScans,
+		DeletePriority:    c.DeletePriority,
+		BatchSize:         c.BatchSize,
+		EscalateThreshold: c.EscalateThreshold,
+		Verify:            c.Verify,
+		Db:                db,
+		Mg:                mg,
 	})
 
 	if err != nil {
@@ -213,11 +288,36 @@ func main() {
 	log.Info().
 		Stringer("min_age", c.MinimumAge).
 		Strs("anchors", c.Anchors).
+		Int("queue_size", c.Queue.MaxSize).
+		Int("batch_size", c.BatchSize).
+		Int("escalate_threshold", c.EscalateThreshold).
+		Stringer("verify_delay", c.Verify.Delay).
 		Msg("Initialised processor")
 
+	return proc
+}
+
+func run(c config, db *sql.DB, proc *processor.Processor) {
 	// Check authentication. If no auth -> warn user.
-	if c.Auth.Username == "" || c.Auth.Password == "" {
+	creds, err := getCredentials(c)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid credentials")
+	}
+
+	access, err := newAccessController(c.Access)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid access configuration")
+	}
+
+	var auth *authenticator
+	if len(creds) == 0 {
 		log.Debug().Msg("Webhooks running without authentication")
+	} else {
+		auth = newAuthenticator(creds, access)
 	}
 
 	// daemon triggers
@@ -245,97 +345,57 @@ func main() {
 		go trigger(proc.Add)
 	}
 
-	// http triggers
-	router := getRouter(c, proc)
-
-	for _, h := range c.Host {
-		go func(host string) {
-			addr := host
-			if !strings.Contains(addr, ":") {
-				addr = fmt.Sprintf("%s:%d", host, c.Port)
-			}
-
-			log.Info().Msgf("Starting server on %s", addr)
-			if err := http.ListenAndServe(addr, router); err != nil {
-				log.Fatal().
-					Str("addr", addr).
-					Err(err).
-					Msg("Failed starting web server")
-			}
-		}(h)
-	}
-
-	log.Info().
-		Int("manual", 1).
-		Int("bernard", len(c.Triggers.Bernard)).
-		Int("inotify", len(c.Triggers.Inotify)).
-		Int("lidarr", len(c.Triggers.Lidarr)).
-		Int("radarr", len(c.Triggers.Radarr)).
-		Int("readarr", len(c.Triggers.Readarr)).
-		Int("sonarr", len(c.Triggers.Sonarr)).
-		Msg("Initialised triggers")
-
 	// targets
-	targets := make([]autoscan.Target, 0)
+	targets := getTargets(c)
 
-	for _, t := range c.Targets.Autoscan {
-		tp, err := ast.New(t)
-		if err != nil {
-			log.Fatal().
-				Err(err).
-				Str("target", "autoscan").
-				Str("target_url", t.URL).
-				Msg("Failed initialising target")
+	// reconciliation of the targets with the files on disk
+	for _, t := range targets {
+		r, ok := unwrapTarget(t).(autoscan.Reconciler)
+		switch {
+		case !ok || r.Reconciliation().Interval <= 0:
+		case unwrapTarget(t) != t:
+			// the queued scans would be sent to every target
+			e := t.(autoscan.Explainer).Explain(autoscan.Scan{})
+			log.Warn().
+				Str("target", e.Target).
+				Str("target_url", e.URL).
+				Msg("Reconciliation is disabled for targets in dry-run mode")
+		default:
+			go proc.Reconcile(r)
 		}
-
-		targets = append(targets, tp)
 	}
 
-	for _, t := range c.Targets.Plex {
-		tp, err := plex.New(t)
-		if err != nil {
-			log.Fatal().
-				Err(err).
-				Str("target", "plex").
-				Str("target_url", t.URL).
-				Msg("Failed initialising target")
-		}
-
-		targets = append(targets, tp)
-	}
+	// verification of the processed scans
+	go proc.Verify(targets)
 
-	for _, t := range c.Targets.Emby {
-		tp, err := emby.New(t)
-		if err != nil {
+	// http triggers and admin api
+	rs := getRoutes(c, auth, access, proc, targets)
+	for _, l := range getListeners(c) {
+		if err := l.validate(auth != nil); err != nil {
 			log.Fatal().
+				Stringer("addr", l).
 				Err(err).
-				Str("target", "emby").
-				Str("target_url", t.URL).
-				Msg("Failed initialising target")
+				Msg("Invalid listener")
 		}
 
-		targets = append(targets, tp)
-	}
-
-	for _, t := range c.Targets.Jellyfin {
-		tp, err := jellyfin.New(t)
-		if err != nil {
-			log.Fatal().
-				Err(err).
-				Str("target", "jellyfin").
-				Str("target_url", t.URL).
-				Msg("Failed initialising target")
+		if !l.serves(routeAdmin, rs.authenticated) && len(l.Routes) == 0 {
+			log.Warn().
+				Stringer("addr", l).
+				Msg("Admin API is not served on listeners reachable from other hosts without credentials")
 		}
 
-		targets = append(targets, tp)
+		go serve(l, getRouter(l, rs))
 	}
 
 	log.Info().
-		Int("autoscan", len(c.Targets.Autoscan)).
-		Int("plex", len(c.Targets.Plex)).
-		Int("emby", len(c.Targets.Emby)).
-		Int("jellyfin", len(c.Targets.Jellyfin)).
-		Msg("Initialised targets")
+		Int("manual", 1).
+		Int("bernard", len(c.Triggers.Bernard)).
+		Int("inotify", len(c.Triggers.Inotify)).
+		Int("lidarr", len(c.Triggers.Lidarr)).
+		Int("radarr", len(c.Triggers.Radarr)).
+		Int("readarr", len(c.Triggers.Readarr)).
+		Int("sonarr", len(c.Triggers.Sonarr)).
+		Msg("Initialised triggers")
 
 	// scan stats
 	if c.ScanStats.Seconds() > 0 {
@@ -428,3 +488,92 @@ func main() {
 		}
 	}
 }
+
+func getTargets(c config) []autoscan.Target {
+	targets := make([]autoscan.Target, 0)
+
+	targets = appendTargets(targets, "autoscan", "target_url", c.Targets.Autoscan, c.DryRun, ast.New,
+		func(t ast.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "plex", "target_url", c.Targets.Plex, c.DryRun,
+		func(t plex.Config) (autoscan.Target, error) {
+			if len(t.EmptyTrash.Anchors) == 0 {
+				t.EmptyTrash.Anchors = c.Anchors
+			}
+
+			return plex.New(t)
+		},
+		func(t plex.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "emby", "target_url", c.Targets.Emby, c.DryRun, emby.New,
+		func(t emby.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "jellyfin", "target_url", c.Targets.Jellyfin, c.DryRun, jellyfin.New,
+		func(t jellyfin.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "kodi", "target_url", c.Targets.Kodi, c.DryRun, kodi.New,
+		func(t kodi.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "webhook", "target_url", c.Targets.Webhook, c.DryRun, webhook.New,
+		func(t webhook.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "exec", "command", c.Targets.Exec, c.DryRun, command.New,
+		func(t command.Config) (string, bool) { return t.Command, t.DryRun })
+
+	targets = appendTargets(targets, "subsonic", "target_url", c.Targets.Subsonic, c.DryRun, subsonic.New,
+		func(t subsonic.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "komga", "target_url", c.Targets.Komga, c.DryRun, komga.New,
+		func(t komga.Config) (string, bool) { return t.URL, t.DryRun })
+
+	targets = appendTargets(targets, "kavita", "target_url", c.Targets.Kavita, c.DryRun, kavita.New,
+		func(t kavita.Config) (string, bool) { return t.URL, t.DryRun })
+
+	log.Info().
+		Int("autoscan", len(c.Targets.Autoscan)).
+		Int("plex", len(c.Targets.Plex)).
+		Int("emby", len(c.Targets.Emby)).
+		Int("jellyfin", len(c.Targets.Jellyfin)).
+		Int("kodi", len(c.Targets.Kodi)).
+		Int("webhook", len(c.Targets.Webhook)).
+		Int("exec", len(c.Targets.Exec)).
+		Int("subsonic", len(c.Targets.Subsonic)).
+		Int("komga", len(c.Targets.Komga)).
+		Int("kavita", len(c.Targets.Kavita)).
+		Bool("dry_run", c.DryRun).
+		Msg("Initialised targets")
+
+	return targets
+}
+
+// appendTargets initialises a target for every config and appends it to targets.
+// describe returns the value logged under field and whether the config enables dry run.
+func appendTargets[C any](
+	targets []autoscan.Target,
+	name, field string,
+	configs []C,
+	dryRun bool,
+	newTarget func(C) (autoscan.Target, error),
+	describe func(C) (string, bool),
+) []autoscan.Target {
+	for _, t := range configs {
+		value, targetDryRun := describe(t)
+
+		tp, err := newTarget(t)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Str("target", name).
+				Str(field, value).
+				Msg("Failed initialising target")
+		}
+
+		if dryRun || targetDryRun {
+			tp = autoscan.DryRun(tp)
+		}
+
+		targets = append(targets, tp)
+	}
+
+	return targets
+}
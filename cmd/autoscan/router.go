@@ -11,6 +11,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/cloudbox/autoscan"
 	"github.com/cloudbox/autoscan/processor"
 	"github.com/cloudbox/autoscan/triggers/a_train"
 	"github.com/cloudbox/autoscan/triggers/lidarr"
@@ -24,13 +25,58 @@ func pattern(name string) string {
 	return fmt.Sprintf("/%s", name)
 }
 
-func createCredentials(c config) map[string]string {
-	creds := make(map[string]string)
-	creds[c.Auth.Username] = c.Auth.Password
-	return creds
+// routes holds the handlers of the routes.
+// They are created once and shared by all listeners, so the triggers and their state are too.
+type routes struct {
+	triggers      http.Handler
+	admin         http.Handler
+	authenticated bool
 }
 
-func getRouter(c config, proc *processor.Processor) chi.Router {
+func getRoutes(c config, auth *authenticator, access *accessController, proc *processor.Processor, targets []autoscan.Target) routes {
+	// HTTP-Triggers
+	ex := &explainer{
+		proc:    proc,
+		targets: targets,
+	}
+
+	triggers := chi.NewRouter()
+
+	// Reject clients before authenticating them if access is limited.
+	if access != nil {
+		triggers.Use(access.Middleware)
+	}
+
+	// Use the auth middleware if credentials are set.
+	if auth != nil {
+		triggers.Use(auth.Middleware)
+	}
+
+	// Acknowledge repeated deliveries without processing them again.
+	if dedup := newDeduplicator(c.Idempotency, proc); dedup != nil {
+		triggers.Use(dedup.Middleware)
+	}
+
+	triggerRoutes(triggers, c, proc.Add, ex)
+
+	// Admin API
+	admin := chi.NewRouter()
+
+	// Use the auth middleware if credentials are set.
+	if auth != nil {
+		admin.Use(auth.Middleware)
+	}
+
+	apiRoutes(admin, proc, targets)
+
+	return routes{
+		triggers:      triggers,
+		admin:         admin,
+		authenticated: auth != nil,
+	}
+}
+
+func getRouter(l listenerConfig, rs routes) chi.Router {
 	r := chi.NewRouter()
 
 	// Middleware
@@ -51,72 +97,74 @@ func getRouter(c config, proc *processor.Processor) chi.Router {
 	// Health check
 	r.Get("/health", healthHandler)
 
-	// HTTP-Triggers
-	r.Route("/triggers", func(r chi.Router) {
-		// Use Basic Auth middleware if username and password are set.
-		if c.Auth.Username != "" && c.Auth.Password != "" {
-			r.Use(middleware.BasicAuth("Autoscan 1.x", createCredentials(c)))
+	if l.serves(routeTriggers, rs.authenticated) {
+		r.Mount("/triggers", rs.triggers)
+	}
+
+	if l.serves(routeAdmin, rs.authenticated) {
+		r.Mount("/api", rs.admin)
+	}
+
+	return r
+}
+
+func triggerRoutes(r chi.Router, c config, callback autoscan.ProcessorFunc, ex *explainer) {
+	// A-Train HTTP-trigger
+	r.Route("/a-train", func(r chi.Router) {
+		trigger, err := a_train.New(c.Triggers.ATrain)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", "a-train").Msg("Failed initialising trigger")
 		}
 
-		// A-Train HTTP-trigger
-		r.Route("/a-train", func(r chi.Router) {
-			trigger, err := a_train.New(c.Triggers.ATrain)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", "a-train").Msg("Failed initialising trigger")
-			}
-
-			r.Post("/{drive}", trigger(proc.Add).ServeHTTP)
-		})
-
-		// Mixed-style Manual HTTP-trigger
-		r.Route("/manual", func(r chi.Router) {
-			trigger, err := manual.New(c.Triggers.Manual)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", "manual").Msg("Failed initialising trigger")
-			}
-
-			r.HandleFunc("/", trigger(proc.Add).ServeHTTP)
-		})
-
-		// OLD-style HTTP-triggers. Can be converted to the /{trigger}/{id} format in a 2.0 release.
-		for _, t := range c.Triggers.Lidarr {
-			trigger, err := lidarr.New(t)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
-			}
-
-			r.Post(pattern(t.Name), trigger(proc.Add).ServeHTTP)
+		r.Post("/{drive}", ex.handler(trigger, callback))
+	})
+
+	// Mixed-style Manual HTTP-trigger
+	r.Route("/manual", func(r chi.Router) {
+		trigger, err := manual.New(c.Triggers.Manual)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", "manual").Msg("Failed initialising trigger")
 		}
 
-		for _, t := range c.Triggers.Radarr {
-			trigger, err := radarr.New(t)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
-			}
+		r.HandleFunc("/", ex.handler(trigger, callback))
+	})
 
-			r.Post(pattern(t.Name), trigger(proc.Add).ServeHTTP)
+	// OLD-style HTTP-triggers. Can be converted to the /{trigger}/{id} format in a 2.0 release.
+	for _, t := range c.Triggers.Lidarr {
+		trigger, err := lidarr.New(t)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
 		}
 
-		for _, t := range c.Triggers.Readarr {
-			trigger, err := readarr.New(t)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
-			}
+		r.Post(pattern(t.Name), ex.handler(trigger, callback))
+	}
 
-			r.Post(pattern(t.Name), trigger(proc.Add).ServeHTTP)
+	for _, t := range c.Triggers.Radarr {
+		trigger, err := radarr.New(t)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
 		}
 
-		for _, t := range c.Triggers.Sonarr {
-			trigger, err := sonarr.New(t)
-			if err != nil {
-				log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
-			}
+		r.Post(pattern(t.Name), ex.handler(trigger, callback))
+	}
 
-			r.Post(pattern(t.Name), trigger(proc.Add).ServeHTTP)
+	for _, t := range c.Triggers.Readarr {
+		trigger, err := readarr.New(t)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
 		}
-	})
 
-	return r
+		r.Post(pattern(t.Name), ex.handler(trigger, callback))
+	}
+
+	for _, t := range c.Triggers.Sonarr {
+		trigger, err := sonarr.New(t)
+		if err != nil {
+			log.Fatal().Err(err).Str("trigger", t.Name).Msg("Failed initialising trigger")
+		}
+
+		r.Post(pattern(t.Name), ex.handler(trigger, callback))
+	}
 }
 
 // Other Handlers
@@ -0,0 +1,62 @@
+package autoscan
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DryRun wraps a Target so that scans are resolved and logged,
+// but never sent to the Target.
+func DryRun(t Target) Target {
+	return dryRun{target: t}
+}
+
+type dryRun struct {
+	target Target
+}
+
+func (d dryRun) Available() error {
+	return d.target.Available()
+}
+
+func (d dryRun) Scan(scan Scan) error {
+	e := d.Explain(scan)
+
+	l := log.With().
+		Str("target", e.Target).
+		Str("url", e.URL).
+		Str("path", e.Folder).
+		Strs("libraries", e.Libraries).
+		Logger()
+
+	if e.Error != "" {
+		l.Warn().
+			Str("error", e.Error).
+			Msg("Dry run, scan could not be resolved")
+		return nil
+	}
+
+	l.Info().Msg("Dry run, scan not sent to target")
+	return nil
+}
+
+func (d dryRun) Explain(scan Scan) Explanation {
+	e := Explanation{
+		Target: fmt.Sprintf("%T", d.target),
+		Folder: scan.Folder,
+		Error:  "target does not support explain",
+	}
+
+	if explainer, ok := d.target.(Explainer); ok {
+		e = explainer.Explain(scan)
+	}
+
+	e.DryRun = true
+	return e
+}
+
+// Unwrap returns the Target which is wrapped in dry-run mode.
+func (d dryRun) Unwrap() Target {
+	return d.target
+}
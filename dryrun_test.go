@@ -0,0 +1,45 @@
+package autoscan
+
+import (
+	"errors"
+	"testing"
+)
+
+type mockTarget struct {
+	scans int
+}
+
+func (t *mockTarget) Scan(Scan) error {
+	t.scans++
+	return errors.New("scan should not be called")
+}
+
+func (t *mockTarget) Available() error {
+	return nil
+}
+
+func (t *mockTarget) Explain(scan Scan) Explanation {
+	return Explanation{
+		Target:    "mock",
+		Folder:    scan.Folder,
+		Libraries: []string{"TV"},
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	target := &mockTarget{}
+	dryRun := DryRun(target)
+
+	if err := dryRun.Scan(Scan{Folder: "/data/TV"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.scans != 0 {
+		t.Errorf("Scan was sent to the target %d times", target.scans)
+	}
+
+	e := dryRun.(Explainer).Explain(Scan{Folder: "/data/TV"})
+	if !e.DryRun || e.Target != "mock" || len(e.Libraries) != 1 {
+		t.Errorf("Explanation does not match: %+v", e)
+	}
+}
@@ -0,0 +1,453 @@
+package autoscan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultLibraryRefresh is the interval at which the libraries of a Target
+// are retrieved again when the target does not configure its own interval.
+const DefaultLibraryRefresh = time.Hour
+
+// libraryMissRefresh limits how often the libraries are retrieved again
+// because a folder did not match any library.
+const libraryMissRefresh = time.Minute
+
+const (
+	// LibraryMatchLongest matches the libraries with the longest path containing the folder.
+	LibraryMatchLongest = "longest"
+	// LibraryMatchAll matches every library containing the folder.
+	LibraryMatchAll = "all"
+)
+
+// LibraryConfig determines how folders are matched to the libraries of a Target.
+type LibraryConfig struct {
+	// Match is either longest (default) or all.
+	Match string `yaml:"match"`
+
+	// CaseInsensitive compares paths regardless of case,
+	// which is useful for media servers running on Windows.
+	CaseInsensitive bool `yaml:"case-insensitive"`
+
+	// Include and Exclude select libraries by name or ID.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	// Rewrite holds rewrite rules which only apply to a single library.
+	Rewrite []LibraryRewrite `yaml:"rewrite"`
+}
+
+// A LibraryRewrite rewrites the folders of scans before they are matched to,
+// and sent to, the library with the given name or ID.
+type LibraryRewrite struct {
+	Library string    `yaml:"library"`
+	Rewrite []Rewrite `yaml:"rewrite"`
+}
+
+// A LibraryMatch is a library containing the folder of a scan.
+type LibraryMatch struct {
+	Library Library
+
+	// Folder is the folder after the rewrite rules of the library.
+	Folder string
+
+	rewrite Rewriter
+}
+
+// Rewrite applies the rewrite rules of the library to a path, such as the file of a scan.
+func (m LibraryMatch) Rewrite(path string) string {
+	if m.rewrite == nil {
+		return path
+	}
+
+	return m.rewrite(path)
+}
+
+// A LibraryCache keeps the libraries of a Target up to date.
+// The libraries are retrieved again in the background on the refresh interval,
+// when a folder does not match any library, or on demand with Refresh.
+// Matching folders only holds up for a retrieval when a folder does not match any library.
+type LibraryCache struct {
+	fetch    func() ([]Library, error)
+	interval time.Duration
+	log      zerolog.Logger
+
+	all        bool
+	fold       bool
+	include    map[string]bool
+	exclude    map[string]bool
+	rewrites   map[string]Rewriter
+	unrewrites map[string]Rewriter
+
+	// refreshing serialises the retrievals, it is held while retrieving the libraries.
+	refreshing sync.Mutex
+
+	// mu guards the libraries, it is never held while retrieving the libraries.
+	mu        sync.Mutex
+	libraries []Library
+	checked   time.Time
+}
+
+// NewLibraryCache retrieves the libraries with fetch.
+// The DefaultLibraryRefresh is used when the interval is not set.
+func NewLibraryCache(fetch func() ([]Library, error), interval time.Duration, lc LibraryConfig, log zerolog.Logger) (*LibraryCache, error) {
+	if interval <= 0 {
+		interval = DefaultLibraryRefresh
+	}
+
+	c := &LibraryCache{
+		fetch:    fetch,
+		interval: interval,
+		log:      log,
+
+		fold:       lc.CaseInsensitive,
+		include:    make(map[string]bool),
+		exclude:    make(map[string]bool),
+		rewrites:   make(map[string]Rewriter),
+		unrewrites: make(map[string]Rewriter),
+	}
+
+	switch lc.Match {
+	case "", LibraryMatchLongest:
+	case LibraryMatchAll:
+		c.all = true
+	default:
+		return nil, fmt.Errorf("libraries: unknown match: %v: %w", lc.Match, ErrFatal)
+	}
+
+	for _, lib := range lc.Include {
+		c.include[lib] = true
+	}
+
+	for _, lib := range lc.Exclude {
+		c.exclude[lib] = true
+	}
+
+	for _, r := range lc.Rewrite {
+		rewriter, err := NewRewriter(r.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("libraries: %v: %w", r.Library, err)
+		}
+
+		unrewriter, err := NewReverseRewriter(r.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("libraries: %v: %w", r.Library, err)
+		}
+
+		c.rewrites[r.Library] = rewriter
+		c.unrewrites[r.Library] = unrewriter
+	}
+
+	if _, err := c.Refresh(); err != nil {
+		return nil, err
+	}
+
+	go c.run()
+	return c, nil
+}
+
+// run refreshes the libraries on the interval.
+// The cached libraries are kept when the target cannot be reached.
+func (c *LibraryCache) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := c.Refresh(); err != nil {
+			c.log.Warn().
+				Err(err).
+				Msg("Failed refreshing libraries")
+		}
+	}
+}
+
+// Refresh retrieves the libraries and logs the libraries which were added or removed.
+func (c *LibraryCache) Refresh() ([]Library, error) {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	return c.refresh()
+}
+
+// refresh must be called with the refreshing lock held.
+func (c *LibraryCache) refresh() ([]Library, error) {
+	c.mu.Lock()
+	c.checked = time.Now()
+	c.mu.Unlock()
+
+	libraries, err := c.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries = c.filter(libraries)
+
+	c.mu.Lock()
+	old := c.libraries
+	c.libraries = libraries
+	c.mu.Unlock()
+
+	if old == nil {
+		// nothing to compare with on the first retrieval
+		c.log.Debug().
+			Interface("libraries", libraries).
+			Msg("Retrieved libraries")
+
+		return libraries, nil
+	}
+
+	added, removed := diffLibraries(old, libraries)
+	for _, lib := range added {
+		c.log.Info().
+			Str("library", lib.Name).
+			Str("path", lib.Path).
+			Msg("Library added")
+	}
+
+	for _, lib := range removed {
+		c.log.Info().
+			Str("library", lib.Name).
+			Str("path", lib.Path).
+			Msg("Library removed")
+	}
+
+	return libraries, nil
+}
+
+// refreshIfOlder refreshes the libraries when they were last checked longer than d ago.
+// The cached libraries are kept when the target cannot be reached.
+func (c *LibraryCache) refreshIfOlder(d time.Duration) {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	c.mu.Lock()
+	checked := c.checked
+	c.mu.Unlock()
+
+	if time.Since(checked) < d {
+		return
+	}
+
+	if _, err := c.refresh(); err != nil {
+		c.log.Warn().
+			Err(err).
+			Msg("Failed refreshing libraries")
+	}
+}
+
+// Libraries returns the cached libraries.
+func (c *LibraryCache) Libraries() []Library {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Library(nil), c.libraries...)
+}
+
+// Roots returns the paths of the cached libraries on the local file system,
+// undoing the rewrite rules of the libraries and then unrewrite,
+// the reverse of the rewrite rules of the Target.
+// Roots within another root are left out.
+func (c *LibraryCache) Roots(unrewrite Rewriter) []string {
+	paths := make([]string, 0)
+	for _, lib := range c.Libraries() {
+		path := lib.Path
+		if r := c.unrewriter(lib); r != nil {
+			path = r(path)
+		}
+
+		paths = append(paths, filepath.Clean(unrewrite(path)))
+	}
+
+	// shorter paths first, so roots are kept before the paths within them
+	sort.Strings(paths)
+
+	roots := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !containsAny(roots, path) {
+			roots = append(roots, path)
+		}
+	}
+
+	return roots
+}
+
+func containsAny(roots []string, path string) bool {
+	for _, root := range roots {
+		if ContainsPath(root, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match returns the cached libraries containing the folder.
+// When no library contains the folder, the libraries are refreshed
+// in case the library was added after they were retrieved.
+func (c *LibraryCache) Match(folder string) []LibraryMatch {
+	if matches := c.match(folder); len(matches) > 0 {
+		return matches
+	}
+
+	c.refreshIfOlder(libraryMissRefresh)
+	return c.match(folder)
+}
+
+// MatchScan returns the libraries containing the folder of a scan like Match,
+// and an error when no library contains the folder.
+func (c *LibraryCache) MatchScan(folder string) ([]LibraryMatch, error) {
+	libraries := c.Match(folder)
+	if len(libraries) == 0 {
+		return nil, fmt.Errorf("%v: failed determining libraries", folder)
+	}
+
+	return libraries, nil
+}
+
+// Explain adds the names of the libraries containing the folder of the explanation,
+// or the error when no library contains the folder.
+// The folder is expected to be rewritten by the Target.
+func (c *LibraryCache) Explain(e Explanation) Explanation {
+	libs, err := c.MatchScan(e.Folder)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+
+	for _, lib := range libs {
+		e.Libraries = append(e.Libraries, lib.Library.Name)
+	}
+
+	return e
+}
+
+// filter removes the libraries which are not included or which are excluded.
+func (c *LibraryCache) filter(libraries []Library) []Library {
+	filtered := make([]Library, 0, len(libraries))
+	for _, lib := range libraries {
+		if len(c.include) > 0 && !c.include[lib.Name] && !c.include[lib.ID] {
+			continue
+		}
+
+		if c.exclude[lib.Name] || c.exclude[lib.ID] {
+			continue
+		}
+
+		filtered = append(filtered, lib)
+	}
+
+	return filtered
+}
+
+// rewriter returns the rewrite rules of a library, by ID first and by name second.
+func (c *LibraryCache) rewriter(lib Library) Rewriter {
+	if r, ok := c.rewrites[lib.ID]; ok && lib.ID != "" {
+		return r
+	}
+
+	return c.rewrites[lib.Name]
+}
+
+// unrewriter returns the reverse of the rewrite rules of a library, by ID first and by name second.
+func (c *LibraryCache) unrewriter(lib Library) Rewriter {
+	if r, ok := c.unrewrites[lib.ID]; ok && lib.ID != "" {
+		return r
+	}
+
+	return c.unrewrites[lib.Name]
+}
+
+func (c *LibraryCache) match(folder string) []LibraryMatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matches := make([]LibraryMatch, 0)
+	longest := -1
+
+	for _, lib := range c.libraries {
+		m := LibraryMatch{
+			Library: lib,
+			rewrite: c.rewriter(lib),
+		}
+
+		m.Folder = m.Rewrite(folder)
+
+		n, ok := containsPath(lib.Path, m.Folder, c.fold)
+		switch {
+		case !ok:
+			continue
+		case c.all:
+		case n > longest:
+			longest = n
+			matches = matches[:0]
+		case n < longest:
+			continue
+		}
+
+		matches = append(matches, m)
+	}
+
+	return matches
+}
+
+// ContainsPath reports whether the path is the root or within the root,
+// comparing whole path segments, so /data/TV does not contain /data/TV4K.
+func ContainsPath(root string, path string) bool {
+	_, ok := containsPath(root, path, false)
+	return ok
+}
+
+// containsPath reports whether the path is the root or within the root,
+// comparing whole path segments, and returns the length of the root.
+// Both forward slashes and backslashes separate segments.
+func containsPath(root string, path string, fold bool) (int, bool) {
+	root = strings.TrimRight(root, `/\`)
+	if len(path) < len(root) {
+		return 0, false
+	}
+
+	prefix := path[:len(root)]
+	if fold && !strings.EqualFold(prefix, root) || !fold && prefix != root {
+		return 0, false
+	}
+
+	if len(path) == len(root) {
+		return len(root), true
+	}
+
+	if sep := path[len(root)]; sep != '/' && sep != '\\' {
+		return 0, false
+	}
+
+	return len(root), true
+}
+
+// diffLibraries returns the libraries which were added and removed.
+func diffLibraries(old []Library, new []Library) (added []Library, removed []Library) {
+	seen := make(map[Library]bool, len(old))
+	for _, lib := range old {
+		seen[lib] = true
+	}
+
+	for _, lib := range new {
+		if !seen[lib] {
+			added = append(added, lib)
+		}
+
+		delete(seen, lib)
+	}
+
+	for _, lib := range old {
+		if seen[lib] {
+			removed = append(removed, lib)
+		}
+	}
+
+	return added, removed
+}
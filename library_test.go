@@ -0,0 +1,337 @@
+package autoscan
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLibraryCache(t *testing.T) {
+	movies := Library{Name: "Movies", Path: "/data/Movies/"}
+	tv := Library{Name: "TV", Path: "/data/TV/"}
+
+	var (
+		calls     int
+		libraries = []Library{movies}
+		fetchErr  error
+	)
+
+	fetch := func() ([]Library, error) {
+		calls++
+		return libraries, fetchErr
+	}
+
+	c, err := NewLibraryCache(fetch, time.Hour, LibraryConfig{}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Could not create library cache: %v", err)
+	}
+
+	if got := matchedLibraries(c.Match("/data/Movies/Interstellar (2014)")); !reflect.DeepEqual(got, []Library{movies}) {
+		t.Errorf("Unexpected match: %v", got)
+	}
+
+	if calls != 1 {
+		t.Errorf("Libraries retrieved %d times, expected once", calls)
+	}
+
+	// a miss refreshes the libraries, picking up the new library
+	libraries = []Library{movies, tv}
+	c.checked = time.Now().Add(-2 * libraryMissRefresh)
+
+	if got := matchedLibraries(c.Match("/data/TV/Westworld")); !reflect.DeepEqual(got, []Library{tv}) {
+		t.Errorf("Unexpected match after refresh: %v", got)
+	}
+
+	if calls != 2 {
+		t.Errorf("Libraries retrieved %d times, expected twice", calls)
+	}
+
+	// misses shortly after a refresh do not retrieve the libraries again
+	if got := c.Match("/data/Music/Queen"); len(got) != 0 {
+		t.Errorf("Unexpected match: %v", got)
+	}
+
+	if calls != 2 {
+		t.Errorf("Libraries retrieved %d times, expected twice", calls)
+	}
+
+	// the cached libraries are kept when the target cannot be reached
+	fetchErr = errors.New("unavailable")
+	c.checked = time.Now().Add(-2 * time.Hour)
+
+	if got := c.Libraries(); !reflect.DeepEqual(got, []Library{movies, tv}) {
+		t.Errorf("Cached libraries were not kept: %v", got)
+	}
+
+	if _, err := c.Refresh(); err == nil {
+		t.Errorf("Expected the error of the target")
+	}
+}
+
+func TestLibraryMatch(t *testing.T) {
+	type Test struct {
+		Name   string
+		Config LibraryConfig
+		Folder string
+		Want   []LibraryMatch
+	}
+
+	libraries := []Library{
+		{ID: "1", Name: "Movies", Path: "/data/Movies/"},
+		{ID: "2", Name: "Movies 4K", Path: "/data/Movies/4K/"},
+		{ID: "3", Name: "Movies Mirror", Path: "/data/Movies"},
+		{ID: "4", Name: "TV", Path: "/data/TV/"},
+		{ID: "5", Name: "Windows", Path: `D:\Media\Music\`},
+	}
+
+	var testCases = []Test{
+		{
+			Name:   "Longest prefix",
+			Folder: "/data/Movies/4K/Interstellar (2014)",
+			Want: []LibraryMatch{
+				{Library: libraries[1], Folder: "/data/Movies/4K/Interstellar (2014)"},
+			},
+		},
+		{
+			Name:   "Libraries sharing the longest prefix",
+			Folder: "/data/Movies/Interstellar (2014)",
+			Want: []LibraryMatch{
+				{Library: libraries[0], Folder: "/data/Movies/Interstellar (2014)"},
+				{Library: libraries[2], Folder: "/data/Movies/Interstellar (2014)"},
+			},
+		},
+		{
+			Name:   "All matches",
+			Config: LibraryConfig{Match: LibraryMatchAll},
+			Folder: "/data/Movies/4K/Interstellar (2014)",
+			Want: []LibraryMatch{
+				{Library: libraries[0], Folder: "/data/Movies/4K/Interstellar (2014)"},
+				{Library: libraries[1], Folder: "/data/Movies/4K/Interstellar (2014)"},
+				{Library: libraries[2], Folder: "/data/Movies/4K/Interstellar (2014)"},
+			},
+		},
+		{
+			Name:   "Path segment boundaries",
+			Folder: "/data/TV 4K/Westworld",
+			Want:   []LibraryMatch{},
+		},
+		{
+			Name:   "Library root",
+			Folder: "/data/TV",
+			Want: []LibraryMatch{
+				{Library: libraries[3], Folder: "/data/TV"},
+			},
+		},
+		{
+			Name:   "Case sensitive by default",
+			Folder: `d:\media\music\Queen`,
+			Want:   []LibraryMatch{},
+		},
+		{
+			Name:   "Case insensitive",
+			Config: LibraryConfig{CaseInsensitive: true},
+			Folder: `d:\media\music\Queen`,
+			Want: []LibraryMatch{
+				{Library: libraries[4], Folder: `d:\media\music\Queen`},
+			},
+		},
+		{
+			Name:   "Include by name and ID",
+			Config: LibraryConfig{Include: []string{"Movies", "3"}},
+			Folder: "/data/Movies/4K/Interstellar (2014)",
+			Want: []LibraryMatch{
+				{Library: libraries[0], Folder: "/data/Movies/4K/Interstellar (2014)"},
+				{Library: libraries[2], Folder: "/data/Movies/4K/Interstellar (2014)"},
+			},
+		},
+		{
+			Name:   "Exclude by name",
+			Config: LibraryConfig{Exclude: []string{"Movies Mirror"}},
+			Folder: "/data/Movies/Interstellar (2014)",
+			Want: []LibraryMatch{
+				{Library: libraries[0], Folder: "/data/Movies/Interstellar (2014)"},
+			},
+		},
+		{
+			Name: "Library rewrite",
+			Config: LibraryConfig{
+				Rewrite: []LibraryRewrite{{
+					Library: "4",
+					Rewrite: []Rewrite{{From: "^/data/Series/", To: "/data/TV/"}},
+				}},
+			},
+			Folder: "/data/Series/Westworld",
+			Want: []LibraryMatch{
+				{Library: libraries[3], Folder: "/data/TV/Westworld"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fetch := func() ([]Library, error) {
+				return libraries, nil
+			}
+
+			c, err := NewLibraryCache(fetch, time.Hour, tc.Config, zerolog.Nop())
+			if err != nil {
+				t.Fatalf("Could not create library cache: %v", err)
+			}
+
+			got := c.Match(tc.Folder)
+			for i := range got {
+				got[i].rewrite = nil
+			}
+
+			if !reflect.DeepEqual(got, tc.Want) {
+				t.Log(got)
+				t.Log(tc.Want)
+				t.Errorf("Matches do not equal")
+			}
+		})
+	}
+}
+
+func TestLibraryExplain(t *testing.T) {
+	libraries := []Library{
+		{ID: "1", Name: "TV", Path: "/data/TV/"},
+		{ID: "2", Name: "Kids", Path: "/data/TV/Kids/"},
+	}
+
+	fetch := func() ([]Library, error) {
+		return libraries, nil
+	}
+
+	c, err := NewLibraryCache(fetch, time.Hour, LibraryConfig{Match: LibraryMatchAll}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Could not create library cache: %v", err)
+	}
+
+	e := c.Explain(Explanation{Target: "test", Folder: "/data/TV/Kids/Bluey"})
+	if want := []string{"TV", "Kids"}; !reflect.DeepEqual(e.Libraries, want) || e.Error != "" {
+		t.Errorf("Libraries do not match: %v vs %v (expected): %v", e.Libraries, want, e.Error)
+	}
+
+	e = c.Explain(Explanation{Target: "test", Folder: "/data/Music/Queen"})
+	if len(e.Libraries) != 0 || e.Error == "" {
+		t.Errorf("Expected an error for a folder outside of the libraries, got %+v", e)
+	}
+
+	if e.Target != "test" || e.Folder != "/data/Music/Queen" {
+		t.Errorf("Explanation was not kept: %+v", e)
+	}
+}
+
+func TestDiffLibraries(t *testing.T) {
+	movies := Library{Name: "Movies", Path: "/data/Movies/"}
+	tv := Library{Name: "TV", Path: "/data/TV/"}
+	music := Library{Name: "Music", Path: "/data/Music/"}
+
+	added, removed := diffLibraries([]Library{movies, tv}, []Library{tv, music})
+	if !reflect.DeepEqual(added, []Library{music}) {
+		t.Errorf("Unexpected added libraries: %v", added)
+	}
+
+	if !reflect.DeepEqual(removed, []Library{movies}) {
+		t.Errorf("Unexpected removed libraries: %v", removed)
+	}
+}
+
+func matchedLibraries(matches []LibraryMatch) []Library {
+	libraries := make([]Library, 0, len(matches))
+	for _, m := range matches {
+		libraries = append(libraries, m.Library)
+	}
+
+	return libraries
+}
+
+func TestLibraryCacheRefreshing(t *testing.T) {
+	movies := Library{Name: "Movies", Path: "/data/Movies/"}
+
+	block := make(chan struct{})
+	blocking := false
+
+	fetch := func() ([]Library, error) {
+		if blocking {
+			<-block
+		}
+
+		return []Library{movies}, nil
+	}
+
+	c, err := NewLibraryCache(fetch, time.Hour, LibraryConfig{}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Could not create library cache: %v", err)
+	}
+
+	blocking = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Refresh()
+	}()
+
+	// matching a cached library does not wait for a slow target
+	matched := make(chan []LibraryMatch)
+	go func() {
+		matched <- c.Match("/data/Movies/Interstellar (2014)")
+	}()
+
+	select {
+	case got := <-matched:
+		if len(got) != 1 {
+			t.Errorf("Unexpected match: %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("Match waited for the libraries to be retrieved")
+	}
+
+	close(block)
+	<-done
+}
+
+func TestLibraryRoots(t *testing.T) {
+	libraries := []Library{
+		{ID: "1", Name: "Movies", Path: "/data/Movies/"},
+		{ID: "2", Name: "TV", Path: "/data/TV/"},
+		{ID: "3", Name: "TV 4K", Path: "/data/TV 4K/"},
+		{ID: "4", Name: "Kids", Path: "/data/TV/Kids/"},
+		{ID: "5", Name: "Music", Path: "/music/"},
+	}
+
+	fetch := func() ([]Library, error) {
+		return libraries, nil
+	}
+
+	lc := LibraryConfig{
+		Rewrite: []LibraryRewrite{{
+			Library: "Music",
+			Rewrite: []Rewrite{{From: "^/data/Music/", To: "/music/"}},
+		}},
+	}
+
+	c, err := NewLibraryCache(fetch, time.Hour, lc, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unrewrite, err := NewReverseRewriter([]Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/mnt/unionfs/Media/Movies",
+		"/mnt/unionfs/Media/Music",
+		"/mnt/unionfs/Media/TV",
+		"/mnt/unionfs/Media/TV 4K",
+	}
+
+	if got := c.Roots(unrewrite); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Roots do not match: %v vs %v (expected)", got, expected)
+	}
+}
@@ -3,8 +3,11 @@ package processor
 import (
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cloudbox/autoscan"
@@ -33,15 +36,20 @@ func newDatastore(db *sql.DB, mg *migrate.Migrator) (*datastore, error) {
 }
 
 const sqlUpsert = `
-INSERT INTO scan (folder, priority, time)
-VALUES (?, ?, ?)
+INSERT INTO scan (folder, priority, time, file, event)
+VALUES (?, ?, ?, ?, ?)
 ON CONFLICT (folder) DO UPDATE SET
 	priority = MAX(excluded.priority, scan.priority),
-	time = excluded.time
+	time = excluded.time,
+	file = CASE WHEN excluded.file = scan.file THEN scan.file ELSE '' END,
+	event = CASE WHEN excluded.file = scan.file AND excluded.event = scan.event THEN scan.event ELSE 'modified' END
 `
 
+// upsert merges the scan with a queued scan of the same folder.
+// Merging scans of different files results in a scan of the whole folder,
+// while merging different events results in a modification.
 func (store *datastore) upsert(tx *sql.Tx, scan autoscan.Scan) error {
-	_, err := tx.Exec(sqlUpsert, scan.Folder, scan.Priority, scan.Time)
+	_, err := tx.Exec(sqlUpsert, scan.Folder, scan.Priority, scan.Time, scan.File, scan.Event)
 	return err
 }
 
@@ -64,6 +72,154 @@ func (store *datastore) Upsert(scans []autoscan.Scan) error {
 	return tx.Commit()
 }
 
+const sqlDropLowest = `
+DELETE FROM scan WHERE folder IN (
+	SELECT folder FROM scan
+	ORDER BY priority ASC, time DESC
+	LIMIT ?
+)
+`
+
+const sqlGetWithin = `
+SELECT folder, priority, time, file, event FROM scan
+WHERE substr(folder, 1, length(?)) = ?
+`
+
+// UpsertLimited upserts the scans while keeping the queue within its maximum size.
+// It returns the number of queued scans which were removed to make room.
+func (store *datastore) UpsertLimited(scans []autoscan.Scan, q QueueConfig) (int, error) {
+	tx, err := store.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := store.upsertLimited(tx, scans, q)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			panic(rollbackErr)
+		}
+
+		return 0, err
+	}
+
+	return removed, tx.Commit()
+}
+
+func (store *datastore) upsertLimited(tx *sql.Tx, scans []autoscan.Scan, q QueueConfig) (int, error) {
+	for _, scan := range scans {
+		if err := store.upsert(tx, scan); err != nil {
+			return 0, err
+		}
+	}
+
+	size, err := store.count(tx)
+	if err != nil || size <= q.MaxSize {
+		return 0, err
+	}
+
+	removed := 0
+	switch q.Policy {
+	case QueueDropLowest:
+		if _, err := tx.Exec(sqlDropLowest, size-q.MaxSize); err != nil {
+			return 0, err
+		}
+
+		return size - q.MaxSize, nil
+
+	case QueueCollapse:
+		for _, root := range q.Roots {
+			n, err := store.collapse(tx, root)
+			if err != nil {
+				return 0, err
+			}
+
+			removed += n
+		}
+
+		size -= removed
+	}
+
+	if size > q.MaxSize {
+		return 0, fmt.Errorf("%d scans queued: %w", size, autoscan.ErrQueueFull)
+	}
+
+	return removed, nil
+}
+
+func (store *datastore) count(tx *sql.Tx) (int, error) {
+	size := 0
+	if err := tx.QueryRow(sqlGetScansRemaining).Scan(&size); err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+
+	return size, nil
+}
+
+// collapse replaces the scans within the root by a single scan of the root,
+// with the highest priority and most recent time of the replaced scans.
+// It returns the number of scans removed from the queue.
+func (store *datastore) collapse(tx *sql.Tx, root string) (int, error) {
+	root = filepath.Clean(root)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+
+	rows, err := tx.Query(sqlGetWithin, prefix, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("collapse: %w", err)
+	}
+
+	scans := make([]autoscan.Scan, 0)
+	for rows.Next() {
+		scan := autoscan.Scan{}
+		if err := rows.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("collapse: %w", err)
+		}
+
+		scans = append(scans, scan)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("collapse: %w", err)
+	}
+
+	if len(scans) == 0 {
+		return 0, nil
+	}
+
+	collapsed := autoscan.Scan{Folder: root}
+	for _, scan := range scans {
+		if _, err := tx.Exec(sqlDelete, scan.Folder); err != nil {
+			return 0, fmt.Errorf("collapse: %w", err)
+		}
+
+		if scan.Priority > collapsed.Priority {
+			collapsed.Priority = scan.Priority
+		}
+
+		if scan.Time.After(collapsed.Time) {
+			collapsed.Time = scan.Time
+		}
+	}
+
+	// the root may have been queued already
+	before, err := store.count(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := store.upsert(tx, collapsed); err != nil {
+		return 0, fmt.Errorf("collapse: %w", err)
+	}
+
+	after, err := store.count(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(scans) - (after - before), nil
+}
+
 const sqlGetScansRemaining = `SELECT COUNT(folder) FROM scan`
 
 func (store *datastore) GetScansRemaining() (int, error) {
@@ -82,7 +238,7 @@ func (store *datastore) GetScansRemaining() (int, error) {
 }
 
 const sqlGetAvailableScan = `
-SELECT folder, priority, time FROM scan
+SELECT folder, priority, time, file, event FROM scan
 WHERE time < ?
 ORDER BY priority DESC, time ASC
 LIMIT 1
@@ -92,7 +248,7 @@ func (store *datastore) GetAvailableScan(minAge time.Duration) (autoscan.Scan, e
 	row := store.QueryRow(sqlGetAvailableScan, now().Add(-1*minAge))
 
 	scan := autoscan.Scan{}
-	err := row.Scan(&scan.Folder, &scan.Priority, &scan.Time)
+	err := row.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return scan, autoscan.ErrNoScans
@@ -103,8 +259,63 @@ func (store *datastore) GetAvailableScan(minAge time.Duration) (autoscan.Scan, e
 	return scan, nil
 }
 
+const sqlGetAvailableScans = `
+SELECT folder, priority, time, file, event FROM scan
+WHERE time < ?
+ORDER BY priority DESC, time ASC
+LIMIT ?
+`
+
+// GetAvailableScans returns up to limit scans which are older than the minimum age,
+// in the same order as GetAvailableScan.
+func (store *datastore) GetAvailableScans(minAge time.Duration, limit int) ([]autoscan.Scan, error) {
+	rows, err := store.Query(sqlGetAvailableScans, now().Add(-1*minAge), limit)
+	if err != nil {
+		return nil, fmt.Errorf("get available: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	defer rows.Close()
+
+	scans := make([]autoscan.Scan, 0)
+	for rows.Next() {
+		scan := autoscan.Scan{}
+		err = rows.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event)
+		if err != nil {
+			return nil, fmt.Errorf("get available: %s: %w", err, autoscan.ErrFatal)
+		}
+
+		scans = append(scans, scan)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get available: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	if len(scans) == 0 {
+		return nil, autoscan.ErrNoScans
+	}
+
+	return scans, nil
+}
+
+const sqlCountAgedScans = `
+SELECT COUNT(*) FROM scan
+WHERE time >= ? AND time < ?
+`
+
+// CountAgedScans returns the number of scans which became available
+// between the two cutoffs of the minimum age.
+func (store *datastore) CountAgedScans(from time.Time, to time.Time) (int, error) {
+	count := 0
+	if err := store.QueryRow(sqlCountAgedScans, from, to).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count aged: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return count, nil
+}
+
 const sqlGetAll = `
-SELECT folder, priority, time FROM scan
+SELECT folder, priority, time, file, event FROM scan
 `
 
 func (store *datastore) GetAll() (scans []autoscan.Scan, err error) {
@@ -116,7 +327,7 @@ func (store *datastore) GetAll() (scans []autoscan.Scan, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		scan := autoscan.Scan{}
-		err = rows.Scan(&scan.Folder, &scan.Priority, &scan.Time)
+		err = rows.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event)
 		if err != nil {
 			return scans, err
 		}
@@ -127,6 +338,32 @@ func (store *datastore) GetAll() (scans []autoscan.Scan, err error) {
 	return scans, rows.Err()
 }
 
+const sqlGetRecent = `
+SELECT folder, priority, time, file, event FROM scan
+ORDER BY time DESC
+LIMIT ?
+`
+
+func (store *datastore) GetRecent(limit int) (scans []autoscan.Scan, err error) {
+	rows, err := store.Query(sqlGetRecent, limit)
+	if err != nil {
+		return scans, fmt.Errorf("get recent: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		scan := autoscan.Scan{}
+		err = rows.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event)
+		if err != nil {
+			return scans, fmt.Errorf("get recent: %s: %w", err, autoscan.ErrFatal)
+		}
+
+		scans = append(scans, scan)
+	}
+
+	return scans, rows.Err()
+}
+
 const sqlDelete = `
 DELETE FROM scan WHERE folder=?
 `
@@ -140,4 +377,268 @@ func (store *datastore) Delete(scan autoscan.Scan) error {
 	return nil
 }
 
+// DeleteScans removes all scans in a single transaction.
+func (store *datastore) DeleteScans(scans []autoscan.Scan) error {
+	tx, err := store.Begin()
+	if err != nil {
+		return fmt.Errorf("delete: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	for _, scan := range scans {
+		if _, err := tx.Exec(sqlDelete, scan.Folder); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				panic(rollbackErr)
+			}
+
+			return fmt.Errorf("delete: %s: %w", err, autoscan.ErrFatal)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+const sqlInsertHistory = `
+INSERT INTO history (folder, target, url, libraries, dry_run, error, time, verified)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+const sqlPruneHistory = `
+DELETE FROM history WHERE time < ?
+`
+
+func (store *datastore) AddHistory(entries []HistoryEntry, retention time.Duration) error {
+	tx, err := store.Begin()
+	if err != nil {
+		return fmt.Errorf("add history: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	for _, e := range entries {
+		libraries, err := json.Marshal(e.Libraries)
+		if err == nil {
+			_, err = tx.Exec(sqlInsertHistory, e.Folder, e.Target, e.URL, string(libraries), e.DryRun, e.Error, e.Time, e.Verified)
+		}
+
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				panic(rollbackErr)
+			}
+
+			return fmt.Errorf("add history: %s: %w", err, autoscan.ErrFatal)
+		}
+	}
+
+	if _, err := tx.Exec(sqlPruneHistory, now().Add(-1*retention)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			panic(rollbackErr)
+		}
+
+		return fmt.Errorf("prune history: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("add history: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+const sqlGetHistory = `
+SELECT folder, target, url, libraries, dry_run, error, time, verified FROM history
+ORDER BY id DESC
+LIMIT ?
+`
+
+func (store *datastore) GetHistory(limit int) ([]HistoryEntry, error) {
+	rows, err := store.Query(sqlGetHistory, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get history: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for rows.Next() {
+		e := HistoryEntry{}
+		libraries := ""
+		verified := sql.NullBool{}
+
+		err = rows.Scan(&e.Folder, &e.Target, &e.URL, &libraries, &e.DryRun, &e.Error, &e.Time, &verified)
+		if err != nil {
+			return entries, fmt.Errorf("get history: %s: %w", err, autoscan.ErrFatal)
+		}
+
+		if verified.Valid {
+			e.Verified = &verified.Bool
+		}
+
+		if err := json.Unmarshal([]byte(libraries), &e.Libraries); err != nil {
+			return entries, fmt.Errorf("get history: %s: %w", err, autoscan.ErrFatal)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+const sqlPruneDeliveries = `
+DELETE FROM delivery WHERE time < ?
+`
+
+const sqlGetDelivery = `
+SELECT EXISTS (SELECT 1 FROM delivery WHERE key=?)
+`
+
+const sqlCompleteDelivery = `
+INSERT INTO delivery (key, time)
+VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET time=excluded.time
+`
+
+// DeliveryCompleted returns whether the key of a delivery has been stored within the window.
+func (store *datastore) DeliveryCompleted(key string, window time.Duration) (bool, error) {
+	tx, err := store.Begin()
+	if err != nil {
+		return false, fmt.Errorf("get delivery: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	completed, err := deliveryCompleted(tx, key, window)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			panic(rollbackErr)
+		}
+
+		return false, fmt.Errorf("get delivery: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("get delivery: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return completed, nil
+}
+
+func deliveryCompleted(tx *sql.Tx, key string, window time.Duration) (bool, error) {
+	if _, err := tx.Exec(sqlPruneDeliveries, now().Add(-1*window)); err != nil {
+		return false, err
+	}
+
+	var completed bool
+	err := tx.QueryRow(sqlGetDelivery, key).Scan(&completed)
+	return completed, err
+}
+
+// CompleteDelivery stores the key of a delivery.
+func (store *datastore) CompleteDelivery(key string) error {
+	_, err := store.Exec(sqlCompleteDelivery, key, now())
+	if err != nil {
+		return fmt.Errorf("complete delivery: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
 var now = time.Now
+
+const sqlUpsertVerification = `
+INSERT INTO verification (folder, priority, file, event, attempt, due)
+VALUES (?, ?, ?, ?, 0, ?)
+ON CONFLICT (folder) DO UPDATE SET
+	priority = excluded.priority,
+	file = excluded.file,
+	event = excluded.event,
+	due = excluded.due
+`
+
+// AddVerifications schedules the verification of the scans.
+// Scans which are already awaiting verification keep their attempt.
+func (store *datastore) AddVerifications(scans []autoscan.Scan, due time.Time) error {
+	tx, err := store.Begin()
+	if err != nil {
+		return fmt.Errorf("add verification: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	for _, scan := range scans {
+		if _, err := tx.Exec(sqlUpsertVerification, scan.Folder, scan.Priority, scan.File, scan.Event, due); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				panic(rollbackErr)
+			}
+
+			return fmt.Errorf("add verification: %s: %w", err, autoscan.ErrFatal)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("add verification: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+// A verification is a processed scan awaiting verification.
+type verification struct {
+	Scan    autoscan.Scan
+	Attempt int
+}
+
+const sqlGetDueVerifications = `
+SELECT folder, priority, file, event, attempt FROM verification
+WHERE due <= ?
+ORDER BY due ASC
+LIMIT ?
+`
+
+func (store *datastore) GetDueVerifications(limit int) ([]verification, error) {
+	rows, err := store.Query(sqlGetDueVerifications, now(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("get verifications: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	defer rows.Close()
+
+	verifications := make([]verification, 0)
+	for rows.Next() {
+		v := verification{}
+		err = rows.Scan(&v.Scan.Folder, &v.Scan.Priority, &v.Scan.File, &v.Scan.Event, &v.Attempt)
+		if err != nil {
+			return nil, fmt.Errorf("get verifications: %s: %w", err, autoscan.ErrFatal)
+		}
+
+		verifications = append(verifications, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get verifications: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return verifications, nil
+}
+
+const sqlUpdateVerification = `
+UPDATE verification SET attempt = ?, due = ? WHERE folder = ?
+`
+
+// RescheduleVerification sets the attempt and due time of a verification.
+func (store *datastore) RescheduleVerification(folder string, attempt int, due time.Time) error {
+	if _, err := store.Exec(sqlUpdateVerification, attempt, due, folder); err != nil {
+		return fmt.Errorf("reschedule verification: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+const sqlDeleteVerification = `
+DELETE FROM verification WHERE folder = ?
+`
+
+func (store *datastore) DeleteVerification(folder string) error {
+	if _, err := store.Exec(sqlDeleteVerification, folder); err != nil {
+		return fmt.Errorf("delete verification: %s: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
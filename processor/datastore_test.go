@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -15,7 +16,7 @@ import (
 )
 
 const sqlGetScan = `
-SELECT folder, priority, time FROM scan
+SELECT folder, priority, time, file, event FROM scan
 WHERE folder = ?
 `
 
@@ -23,7 +24,7 @@ func (store *datastore) GetScan(folder string) (autoscan.Scan, error) {
 	row := store.QueryRow(sqlGetScan, folder)
 
 	scan := autoscan.Scan{}
-	err := row.Scan(&scan.Folder, &scan.Priority, &scan.Time)
+	err := row.Scan(&scan.Folder, &scan.Priority, &scan.Time, &scan.File, &scan.Event)
 
 	return scan, err
 }
@@ -91,6 +92,44 @@ func TestUpsert(t *testing.T) {
 				Time:     time.Time{}.Add(3),
 			},
 		},
+		{
+			Name: "File is kept when all scans are of the same file",
+			Scans: []autoscan.Scan{
+				{Folder: "Season 1", File: "Season 1/S01E01.mkv", Time: time.Time{}.Add(1)},
+				{Folder: "Season 1", File: "Season 1/S01E01.mkv", Time: time.Time{}.Add(2)},
+			},
+			WantScan: autoscan.Scan{
+				Folder: "Season 1",
+				File:   "Season 1/S01E01.mkv",
+				Time:   time.Time{}.Add(2),
+			},
+		},
+		{
+			Name: "Different events on the same file result in a modification",
+			Scans: []autoscan.Scan{
+				{Folder: "Season 1", File: "Season 1/S01E01.mkv", Event: autoscan.EventDeleted, Time: time.Time{}.Add(1)},
+				{Folder: "Season 1", File: "Season 1/S01E01.mkv", Event: autoscan.EventCreated, Time: time.Time{}.Add(2)},
+			},
+			WantScan: autoscan.Scan{
+				Folder: "Season 1",
+				File:   "Season 1/S01E01.mkv",
+				Time:   time.Time{}.Add(2),
+				Event:  autoscan.EventModified,
+			},
+		},
+		{
+			Name: "Scans of different files scan the whole folder",
+			Scans: []autoscan.Scan{
+				{Folder: "Season 1", File: "Season 1/S01E01.mkv", Time: time.Time{}.Add(1)},
+				{Folder: "Season 1", File: "Season 1/S01E02.mkv", Time: time.Time{}.Add(2)},
+				{Folder: "Season 1", File: "Season 1/S01E02.mkv", Time: time.Time{}.Add(3)},
+			},
+			WantScan: autoscan.Scan{
+				Folder: "Season 1",
+				Time:   time.Time{}.Add(3),
+				Event:  autoscan.EventModified,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -242,3 +281,207 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestUpsertLimited(t *testing.T) {
+	type Test struct {
+		Name        string
+		Queue       QueueConfig
+		GiveQueued  []autoscan.Scan
+		GiveScans   []autoscan.Scan
+		WantScans   []autoscan.Scan
+		WantRemoved int
+		WantErr     error
+	}
+
+	testTime := time.Time{}.Add(time.Hour)
+
+	queued := []autoscan.Scan{
+		{Folder: "/Media/TV/Westworld/Season 1", Priority: 2, Time: testTime},
+		{Folder: "/Media/TV/Westworld/Season 2", Priority: 1, Time: testTime},
+		{Folder: "/Media/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(1)},
+	}
+
+	var testCases = []Test{
+		{
+			Name:       "Updating a queued scan does not count towards the limit",
+			Queue:      QueueConfig{MaxSize: 3, Policy: QueueReject},
+			GiveQueued: queued,
+			GiveScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 5, Time: testTime},
+			},
+			WantScans: []autoscan.Scan{
+				{Folder: "/Media/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(1)},
+				{Folder: "/Media/TV/Westworld/Season 1", Priority: 2, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 5, Time: testTime},
+			},
+		},
+		{
+			Name:       "Reject all scans when the queue is full",
+			Queue:      QueueConfig{MaxSize: 3, Policy: QueueReject},
+			GiveQueued: queued,
+			GiveScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 5, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 3", Priority: 5, Time: testTime},
+			},
+			WantScans: []autoscan.Scan{
+				{Folder: "/Media/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(1)},
+				{Folder: "/Media/TV/Westworld/Season 1", Priority: 2, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 1, Time: testTime},
+			},
+			WantErr: autoscan.ErrQueueFull,
+		},
+		{
+			Name:       "Drop the most recent scan with the lowest priority",
+			Queue:      QueueConfig{MaxSize: 3, Policy: QueueDropLowest},
+			GiveQueued: queued,
+			GiveScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 3", Priority: 5, Time: testTime},
+			},
+			WantScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 1", Priority: 2, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 1, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 3", Priority: 5, Time: testTime},
+			},
+			WantRemoved: 1,
+		},
+		{
+			Name:       "Collapse scans into their root",
+			Queue:      QueueConfig{MaxSize: 3, Policy: QueueCollapse, Roots: []string{"/Media/TV/"}},
+			GiveQueued: queued,
+			GiveScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 3", Priority: 3, Time: testTime.Add(2)},
+			},
+			WantScans: []autoscan.Scan{
+				{Folder: "/Media/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(1)},
+				{Folder: "/Media/TV", Priority: 3, Time: testTime.Add(2)},
+			},
+			WantRemoved: 2,
+		},
+		{
+			Name:       "Reject scans when collapsing does not free enough space",
+			Queue:      QueueConfig{MaxSize: 3, Policy: QueueCollapse, Roots: []string{"/Media/Music"}},
+			GiveQueued: queued,
+			GiveScans: []autoscan.Scan{
+				{Folder: "/Media/TV/Westworld/Season 3", Priority: 3, Time: testTime},
+			},
+			WantScans: []autoscan.Scan{
+				{Folder: "/Media/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(1)},
+				{Folder: "/Media/TV/Westworld/Season 1", Priority: 2, Time: testTime},
+				{Folder: "/Media/TV/Westworld/Season 2", Priority: 1, Time: testTime},
+			},
+			WantErr: autoscan.ErrQueueFull,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := getDatastore(t)
+			if err := store.Upsert(tc.GiveQueued); err != nil {
+				t.Fatal(err)
+			}
+
+			removed, err := store.UpsertLimited(tc.GiveScans, tc.Queue)
+			if !errors.Is(err, tc.WantErr) {
+				t.Fatalf("Different error: %v, want %v", err, tc.WantErr)
+			}
+
+			if removed != tc.WantRemoved {
+				t.Errorf("Removed %d scans, want %d", removed, tc.WantRemoved)
+			}
+
+			scans, err := store.GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Slice(scans, func(i, j int) bool {
+				return scans[i].Folder < scans[j].Folder
+			})
+
+			if !reflect.DeepEqual(scans, tc.WantScans) {
+				t.Log(scans)
+				t.Errorf("Scans do not match")
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store := getDatastore(t)
+	testTime := time.Now().UTC()
+
+	now = func() time.Time {
+		return testTime
+	}
+
+	err := store.AddHistory([]HistoryEntry{
+		{Folder: "old", Target: "plex", Time: testTime.Add(-2 * time.Hour)},
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.AddHistory([]HistoryEntry{
+		{Folder: "1", Target: "plex", Libraries: []string{"TV"}, Time: testTime},
+		{Folder: "1", Target: "jellyfin", DryRun: true, Error: "failed determining library", Time: testTime},
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.GetHistory(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HistoryEntry{
+		{Folder: "1", Target: "jellyfin", DryRun: true, Error: "failed determining library", Time: testTime},
+		{Folder: "1", Target: "plex", Libraries: []string{"TV"}, Time: testTime},
+	}
+
+	if !reflect.DeepEqual(entries, want) {
+		t.Log(entries)
+		t.Errorf("History does not match")
+	}
+}
+
+func TestDeliveryCompleted(t *testing.T) {
+	store := getDatastore(t)
+	testTime := time.Now().UTC()
+
+	now = func() time.Time {
+		return testTime
+	}
+
+	completed := func(key string, want bool) {
+		t.Helper()
+
+		completed, err := store.DeliveryCompleted(key, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if completed != want {
+			t.Errorf("Completed %q: %v, want %v", key, completed, want)
+		}
+	}
+
+	completed("a", false)
+
+	for _, key := range []string{"a", "b"} {
+		if err := store.CompleteDelivery(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	completed("a", true)
+	completed("b", true)
+	completed("c", false)
+
+	// keys outside of the window are forgotten
+	now = func() time.Time {
+		return testTime.Add(2 * time.Hour)
+	}
+
+	completed("b", false)
+}
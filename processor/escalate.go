@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// An escalation replaces the scans within a library of a target by a refresh of the library.
+type escalation struct {
+	target  int
+	library autoscan.Library
+	scans   []autoscan.Scan
+}
+
+// An escalationCheck is the state of the queue at the last check which found no escalation.
+type escalationCheck struct {
+	added  int64
+	cutoff time.Time
+}
+
+// findEscalation matches all available scans against the libraries of the targets,
+// but only when scans were added to the queue, or became available, since the last check.
+// Processing scans only removes scans, which never leads to an escalation.
+func (p *Processor) findEscalation(targets []autoscan.Target) (*escalation, error) {
+	check := escalationCheck{
+		added:  atomic.LoadInt64(&p.added),
+		cutoff: now().Add(-1 * p.minimumAge),
+	}
+
+	if check.added == p.escalated.added {
+		aged, err := p.store.CountAgedScans(p.escalated.cutoff, check.cutoff)
+		if err != nil {
+			return nil, err
+		}
+
+		if aged == 0 {
+			p.escalated.cutoff = check.cutoff
+			return nil, nil
+		}
+	}
+
+	scans, err := p.store.GetAvailableScans(p.minimumAge, -1)
+	switch {
+	case errors.Is(err, autoscan.ErrNoScans):
+		p.escalated = check
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	e := findEscalation(targets, scans, p.escalate)
+	if e == nil {
+		// an escalation is checked again until its refresh succeeded
+		p.escalated = check
+	}
+
+	return e, nil
+}
+
+// findEscalation returns the library with the most scans among all targets
+// which can refresh libraries, when the number of scans reaches the threshold.
+func findEscalation(targets []autoscan.Target, scans []autoscan.Scan, threshold int) *escalation {
+	var found *escalation
+
+	for i, target := range targets {
+		refresher, ok := target.(autoscan.LibraryRefresher)
+		if !ok {
+			continue
+		}
+
+		groups := make(map[autoscan.Library][]autoscan.Scan)
+		for _, scan := range scans {
+			for _, lib := range refresher.ScanLibraries(scan) {
+				groups[lib] = append(groups[lib], scan)
+			}
+		}
+
+		for lib, group := range groups {
+			if len(group) < threshold || (found != nil && len(group) <= len(found.scans)) {
+				continue
+			}
+
+			found = &escalation{
+				target:  i,
+				library: lib,
+				scans:   group,
+			}
+		}
+	}
+
+	return found
+}
+
+// refreshLibrary refreshes the libraries containing the scans of the escalation on every target
+// which can refresh libraries, and sends the scans to the other targets.
+// The scans are removed from the queue once all targets succeeded.
+func (p *Processor) refreshLibrary(targets []autoscan.Target, e escalation) error {
+	g := new(errgroup.Group)
+	entries := make([][]HistoryEntry, len(targets))
+
+	for i, target := range targets {
+		i, target := i, target
+		g.Go(func() error {
+			refresher, ok := target.(autoscan.LibraryRefresher)
+			if !ok {
+				err := scanTarget(target, e.scans)
+				for _, scan := range e.scans {
+					entries[i] = append(entries[i], historyEntries([]autoscan.Target{target}, scan, []error{err})...)
+				}
+
+				return err
+			}
+
+			libraries := scanLibraries(refresher, e.scans)
+
+			// refresh once when every library is refreshed anyway
+			if all, ok := refresher.(autoscan.AllLibrariesRefresher); ok && len(libraries) > 0 {
+				err := all.RefreshAll()
+				for _, lib := range libraries {
+					entries[i] = append(entries[i], refreshEntry(target, lib, err))
+				}
+
+				return err
+			}
+
+			var err error
+			for _, lib := range libraries {
+				err = refresher.RefreshLibrary(lib)
+				entries[i] = append(entries[i], refreshEntry(target, lib, err))
+				if err != nil {
+					break
+				}
+			}
+
+			return err
+		})
+	}
+
+	err := g.Wait()
+
+	history := make([]HistoryEntry, 0)
+	for _, targetEntries := range entries {
+		history = append(history, targetEntries...)
+	}
+
+	if histErr := p.store.AddHistory(history, historyRetention); histErr != nil && err == nil {
+		return histErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := p.store.DeleteScans(e.scans); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&p.processed, int64(len(e.scans)))
+
+	log.Info().
+		Str("library", e.library.Name).
+		Str("path", e.library.Path).
+		Int("scans", len(e.scans)).
+		Msg("Scans replaced by a refresh of the library")
+
+	return nil
+}
+
+// scanLibraries returns the distinct libraries the scans would be sent to.
+func scanLibraries(refresher autoscan.LibraryRefresher, scans []autoscan.Scan) []autoscan.Library {
+	seen := make(map[autoscan.Library]bool)
+	libraries := make([]autoscan.Library, 0)
+
+	for _, scan := range scans {
+		for _, lib := range refresher.ScanLibraries(scan) {
+			if seen[lib] {
+				continue
+			}
+
+			seen[lib] = true
+			libraries = append(libraries, lib)
+		}
+	}
+
+	return libraries
+}
+
+func refreshEntry(target autoscan.Target, lib autoscan.Library, err error) HistoryEntry {
+	e := autoscan.Explanation{
+		Target: fmt.Sprintf("%T", target),
+	}
+
+	if explainer, ok := target.(autoscan.Explainer); ok {
+		e = explainer.Explain(autoscan.Scan{Folder: lib.Path})
+	}
+
+	entry := HistoryEntry{
+		Folder:    lib.Path,
+		Target:    e.Target,
+		URL:       e.URL,
+		Libraries: []string{lib.Name},
+		Time:      now(),
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return entry
+}
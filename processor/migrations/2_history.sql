@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS history (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "folder" TEXT NOT NULL,
+    "target" TEXT NOT NULL,
+    "url" TEXT NOT NULL,
+    "libraries" TEXT NOT NULL,
+    "dry_run" BOOLEAN NOT NULL,
+    "error" TEXT NOT NULL,
+    "time" DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS history_time ON history (time);
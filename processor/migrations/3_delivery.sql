@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS delivery (
+    "key" TEXT NOT NULL,
+    "time" DATETIME NOT NULL,
+    PRIMARY KEY(key)
+);
+
+CREATE INDEX IF NOT EXISTS delivery_time ON delivery (time);
@@ -0,0 +1 @@
+ALTER TABLE scan ADD COLUMN "event" TEXT NOT NULL DEFAULT '';
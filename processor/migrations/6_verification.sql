@@ -0,0 +1,13 @@
+ALTER TABLE history ADD COLUMN "verified" BOOLEAN;
+
+CREATE TABLE IF NOT EXISTS verification (
+    "folder" TEXT NOT NULL,
+    "priority" INTEGER NOT NULL,
+    "file" TEXT NOT NULL,
+    "event" TEXT NOT NULL,
+    "attempt" INTEGER NOT NULL,
+    "due" DATETIME NOT NULL,
+    PRIMARY KEY(folder)
+);
+
+CREATE INDEX IF NOT EXISTS verification_due ON verification (due);
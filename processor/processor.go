@@ -2,6 +2,7 @@ package processor
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/cloudbox/autoscan"
 	"github.com/cloudbox/autoscan/migrate"
+	"github.com/rs/zerolog/log"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -17,30 +19,114 @@ import (
 type Config struct {
 	Anchors    []string
 	MinimumAge time.Duration
+	Queue      QueueConfig
+
+	// FileScans keeps the file of a scan, so targets can scan the file instead of its folder.
+	FileScans bool
+
+	// DeletePriority overrides the priority of deletions when set.
+	DeletePriority *int
+
+	// BatchSize is the maximum number of scans processed at once.
+	// Targets which support it receive these scans in a single request.
+	BatchSize int
+
+	// Verify verifies processed scans on targets which support it.
+	Verify VerifyConfig
+
+	// EscalateThreshold is the number of available scans within a single library
+	// at which the library is refreshed as a whole instead. Disabled when zero.
+	EscalateThreshold int
 
 	Db *sql.DB
 	Mg *migrate.Migrator
 }
 
+// Policies applied when the queue reaches its maximum size.
+const (
+	// QueueReject rejects new scans with autoscan.ErrQueueFull.
+	QueueReject = "reject"
+	// QueueDropLowest drops the scans with the lowest priority, the most recent first.
+	QueueDropLowest = "drop-lowest"
+	// QueueCollapse replaces the scans within a root by a scan of the root itself.
+	// New scans are rejected when collapsing does not free enough space.
+	QueueCollapse = "collapse"
+)
+
+// A QueueConfig limits the number of scans in the queue.
+// The queue is unlimited when MaxSize is zero.
+type QueueConfig struct {
+	MaxSize int      `yaml:"max-size"`
+	Policy  string   `yaml:"policy"`
+	Roots   []string `yaml:"roots"`
+}
+
+func (c QueueConfig) validate() error {
+	switch c.Policy {
+	case "", QueueReject, QueueDropLowest:
+	case QueueCollapse:
+		if len(c.Roots) == 0 {
+			return errors.New("queue: the collapse policy requires roots")
+		}
+	default:
+		return fmt.Errorf("queue: unknown policy: %v", c.Policy)
+	}
+
+	if c.MaxSize < 0 {
+		return errors.New("queue: max size must be positive")
+	}
+
+	return nil
+}
+
 func New(c Config) (*Processor, error) {
+	if err := c.Queue.validate(); err != nil {
+		return nil, err
+	}
+
+	if c.Queue.Policy == "" {
+		c.Queue.Policy = QueueReject
+	}
+
 	store, err := newDatastore(c.Db, c.Mg)
 	if err != nil {
 		return nil, err
 	}
 
+	if c.BatchSize < 1 {
+		c.BatchSize = 1
+	}
+
 	proc := &Processor{
-		anchors:    c.Anchors,
-		minimumAge: c.MinimumAge,
-		store:      store,
+		anchors:        c.Anchors,
+		minimumAge:     c.MinimumAge,
+		queue:          c.Queue,
+		fileScans:      c.FileScans,
+		deletePriority: c.DeletePriority,
+		batchSize:      c.BatchSize,
+		escalate:       c.EscalateThreshold,
+		verify:         c.Verify,
+		store:          store,
 	}
 	return proc, nil
 }
 
 type Processor struct {
-	anchors    []string
-	minimumAge time.Duration
-	store      *datastore
-	processed  int64
+	anchors        []string
+	minimumAge     time.Duration
+	queue          QueueConfig
+	fileScans      bool
+	deletePriority *int
+	batchSize      int
+	escalate       int
+	verify         VerifyConfig
+	store          *datastore
+	processed      int64
+
+	// added counts the inserts into the queue, so escalation is only
+	// checked again when scans were added or became available.
+	added     int64
+	escalated escalationCheck
 }
 
 type ScanInfo struct {
@@ -48,69 +134,273 @@ type ScanInfo struct {
 	IsFolder bool
 }
 
+// prioritise applies the delete priority to the scans of deleted paths.
+func (p *Processor) prioritise(scans []autoscan.Scan) []autoscan.Scan {
+	if p.deletePriority == nil {
+		return scans
+	}
+
+	scans = append([]autoscan.Scan(nil), scans...)
+	for i := range scans {
+		if scans[i].Event == autoscan.EventDeleted {
+			scans[i].Priority = *p.deletePriority
+		}
+	}
+
+	return scans
+}
+
 func (p *Processor) Add(scans ...autoscan.Scan) error {
+	scans = p.prioritise(scans)
+
 	if len(p.anchors) > 0 {
 		// forget rclone VFS cache
-		infoMap := make(map[string]ScanInfo, len(scans))
-		uniqueness := make(map[string]struct{}, len(scans))
-		argc, argv := 1, make([]string, 0, len(scans))
-		for _, scan := range scans {
-			if scan.Path == "" {
-				continue
-			}
-			info := ScanInfo{Exists: false, IsFolder: false}
-			folder, relativePath, arg := scan.Folder, scan.Path, scan.Path
-			for {
-				if fileInfo, err := os.Stat(folder); os.IsNotExist(err) {
-					arg = relativePath
-					folder = filepath.Dir(folder)
-					relativePath = filepath.Dir(relativePath)
-					continue
-				} else if folder == scan.Folder {
-					info.Exists, info.IsFolder = true, fileInfo.IsDir()
-				}
-				infoMap[scan.Folder] = info
-				if _, ok := uniqueness[arg]; ok {
-					break
-				}
-				uniqueness[arg] = struct{}{}
-				if !info.Exists || info.IsFolder {
-					arg = fmt.Sprintf("dir%d=%s", argc, arg)
-				} else {
-					arg = fmt.Sprintf("file%d=%s", argc, arg)
-				}
-				argc++
-				argv = append(argv, arg)
-				break
-			}
-		}
+		infoMap, argv := forgetArgs(scans)
 		if len(argv) > 0 {
 			autoscan.RcloneForget(argv)
 		}
 		// check if scans are duplicate
-		uniqueness = make(map[string]struct{}, len(scans))
+		uniqueness := make(map[string]int, len(scans))
 		result := make([]autoscan.Scan, 0, len(scans))
 		for _, scan := range scans {
-			if info, ok := infoMap[scan.Folder]; ok {
-				if info.Exists {
-					if !info.IsFolder {
-						scan.Folder = filepath.Dir(scan.Folder)
-					}
-				} else if fileInfo, err := os.Stat(scan.Folder); os.IsNotExist(err) {
-					continue
-				} else if !fileInfo.IsDir() {
-					scan.Folder = filepath.Dir(scan.Folder)
-				}
+			folder, ok := resolveFolder(scan, infoMap)
+			if !ok {
+				continue
+			}
+			if folder != scan.Folder && scan.File == "" {
+				// a file or deleted path was given as the folder
+				scan.File = scan.Folder
 			}
-			if _, ok := uniqueness[scan.Folder]; ok {
+			scan.Folder = folder
+			if i, ok := uniqueness[folder]; ok {
+				// scans of different files within a folder scan the whole folder
+				if result[i].File != scan.File || result[i].Event != scan.Event {
+					result[i].File = ""
+					result[i].Event = autoscan.EventModified
+				}
 				continue
 			}
-			uniqueness[scan.Folder] = struct{}{}
+			uniqueness[folder] = len(result)
 			result = append(result, scan)
 		}
 		scans = result
 	}
-	return p.store.Upsert(scans)
+
+	if !p.fileScans {
+		scans = append([]autoscan.Scan(nil), scans...)
+		for i := range scans {
+			if scans[i].File != "" {
+				// the event of the file does not apply to its folder
+				scans[i].File = ""
+				scans[i].Event = autoscan.EventModified
+			}
+		}
+	}
+
+	return p.insert(scans)
+}
+
+// insert queues the scans within the limits of the queue.
+func (p *Processor) insert(scans []autoscan.Scan) error {
+	atomic.AddInt64(&p.added, 1)
+
+	if p.queue.MaxSize == 0 {
+		return p.store.Upsert(scans)
+	}
+
+	removed, err := p.store.UpsertLimited(scans, p.queue)
+	if removed > 0 {
+		log.Warn().
+			Int("max_size", p.queue.MaxSize).
+			Int("removed", removed).
+			Str("policy", p.queue.Policy).
+			Msg("Queue is full, removed scans to make room")
+	}
+
+	return err
+}
+
+// forgetArgs checks which scans exist on the file system and determines
+// the arguments for the rclone vfs/forget call.
+// Scans without a path are not checked.
+func forgetArgs(scans []autoscan.Scan) (map[string]ScanInfo, []string) {
+	infoMap := make(map[string]ScanInfo, len(scans))
+	uniqueness := make(map[string]struct{}, len(scans))
+	argc, argv := 1, make([]string, 0, len(scans))
+	for _, scan := range scans {
+		if scan.Path == "" {
+			continue
+		}
+		info := ScanInfo{Exists: false, IsFolder: false}
+		folder, relativePath, arg := scan.Folder, scan.Path, scan.Path
+		for {
+			if fileInfo, err := os.Stat(folder); os.IsNotExist(err) {
+				arg = relativePath
+				folder = filepath.Dir(folder)
+				relativePath = filepath.Dir(relativePath)
+				continue
+			} else if folder == scan.Folder {
+				info.Exists, info.IsFolder = true, fileInfo.IsDir()
+			}
+			infoMap[scan.Folder] = info
+			if _, ok := uniqueness[arg]; ok {
+				break
+			}
+			uniqueness[arg] = struct{}{}
+			if !info.Exists || info.IsFolder {
+				arg = fmt.Sprintf("dir%d=%s", argc, arg)
+			} else {
+				arg = fmt.Sprintf("file%d=%s", argc, arg)
+			}
+			argc++
+			argv = append(argv, arg)
+			break
+		}
+	}
+	return infoMap, argv
+}
+
+// resolveFolder returns the folder which should be queued for the scan.
+// Files fall back to their parent folder, while scans which do not exist
+// on the file system are not queued at all.
+func resolveFolder(scan autoscan.Scan, infoMap map[string]ScanInfo) (string, bool) {
+	info, ok := infoMap[scan.Folder]
+	switch {
+	case !ok:
+		return scan.Folder, true
+	case info.Exists && !info.IsFolder:
+		return filepath.Dir(scan.Folder), true
+	case info.Exists:
+		return scan.Folder, true
+	}
+
+	// the folder may have appeared after forgetting the rclone VFS cache
+	fileInfo, err := os.Stat(scan.Folder)
+	switch {
+	case os.IsNotExist(err) && scan.Event == autoscan.EventDeleted:
+		// deletions are scanned from the closest folder which still exists
+		return existingParent(scan.Folder), true
+	case os.IsNotExist(err):
+		return "", false
+	case err == nil && !fileInfo.IsDir():
+		return filepath.Dir(scan.Folder), true
+	}
+
+	return scan.Folder, true
+}
+
+// existingParent returns the closest parent folder of the path which exists.
+func existingParent(path string) string {
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return parent
+		}
+
+		if _, err := os.Stat(parent); err == nil {
+			return parent
+		}
+
+		path = parent
+	}
+}
+
+// An Explanation describes what Add would do with a set of scans.
+type Explanation struct {
+	Anchors      map[string]bool   `json:"anchors,omitempty"`
+	RcloneForget []string          `json:"rclone_forget,omitempty"`
+	Scans        []ScanExplanation `json:"scans"`
+}
+
+type ScanExplanation struct {
+	Folder   string         `json:"folder"`
+	Path     string         `json:"path,omitempty"`
+	Event    autoscan.Event `json:"event,omitempty"`
+	Priority int            `json:"priority"`
+	Checked  bool           `json:"checked"`
+	Exists   bool           `json:"exists"`
+	IsFolder bool           `json:"is_folder"`
+	Queued   string         `json:"queued,omitempty"`
+	File     string         `json:"file,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// Explain describes what Add would do with the given scans
+// without forgetting the rclone VFS cache or queueing the scans.
+func (p *Processor) Explain(scans ...autoscan.Scan) Explanation {
+	e := Explanation{
+		Anchors: make(map[string]bool, len(p.anchors)),
+		Scans:   make([]ScanExplanation, 0, len(scans)),
+	}
+
+	for _, anchor := range p.anchors {
+		e.Anchors[anchor] = fileExists(anchor)
+	}
+
+	scans = p.prioritise(scans)
+
+	infoMap := make(map[string]ScanInfo)
+	if len(p.anchors) > 0 {
+		infoMap, e.RcloneForget = forgetArgs(scans)
+	}
+
+	uniqueness := make(map[string]struct{}, len(scans))
+	for _, scan := range scans {
+		se := ScanExplanation{
+			Folder:   scan.Folder,
+			Path:     scan.Path,
+			Event:    scan.Event,
+			Priority: scan.Priority,
+		}
+
+		info, checked := infoMap[scan.Folder]
+		se.Checked, se.Exists, se.IsFolder = checked, info.Exists, info.IsFolder
+
+		folder, ok := scan.Folder, true
+		switch {
+		case len(p.anchors) == 0:
+			se.Reason = "no anchors configured, existence check skipped"
+		case !checked:
+			se.Reason = "no path given, existence check skipped"
+		default:
+			folder, ok = resolveFolder(scan, infoMap)
+		}
+
+		switch {
+		case !ok:
+			se.Reason = "does not exist on the file system"
+		case folder != scan.Folder && scan.Event == autoscan.EventDeleted:
+			se.Reason = "deleted, falling back to the closest existing parent"
+		case folder != scan.Folder:
+			se.Reason = "not a folder, falling back to parent"
+		}
+
+		if ok {
+			if _, exists := uniqueness[folder]; exists {
+				se.Reason = "duplicate of another scan"
+			} else {
+				uniqueness[folder] = struct{}{}
+				se.Queued = folder
+			}
+
+			file := scan.File
+			if folder != scan.Folder && file == "" {
+				file = scan.Folder
+			}
+
+			switch {
+			case p.fileScans:
+				se.File = file
+			case file != "":
+				// the event of the file does not apply to its folder
+				se.Event = autoscan.EventModified
+			}
+		}
+
+		e.Scans = append(e.Scans, se)
+	}
+
+	return e
 }
 
 // ScansRemaining returns the amount of scans remaining
@@ -118,6 +408,11 @@ func (p *Processor) ScansRemaining() (int, error) {
 	return p.store.GetScansRemaining()
 }
 
+// RecentScans returns up to limit of the most recently queued scans
+func (p *Processor) RecentScans(limit int) ([]autoscan.Scan, error) {
+	return p.store.GetRecent(limit)
+}
+
 // ScansProcessed returns the amount of scans processed
 func (p *Processor) ScansProcessed() int64 {
 	return atomic.LoadInt64(&p.processed)
@@ -138,44 +433,166 @@ func (p *Processor) CheckAvailability(targets []autoscan.Target) error {
 	return g.Wait()
 }
 
-func (p *Processor) callTargets(targets []autoscan.Target, scan autoscan.Scan) error {
+func (p *Processor) callTargets(targets []autoscan.Target, scans []autoscan.Scan) error {
 	g := new(errgroup.Group)
+	errs := make([]error, len(targets))
 
-	for _, target := range targets {
-		target := target
+	for i, target := range targets {
+		i, target := i, target
 		g.Go(func() error {
-			return target.Scan(scan)
+			errs[i] = scanTarget(target, scans)
+			return errs[i]
 		})
 	}
 
-	return g.Wait()
+	err := g.Wait()
+	if histErr := p.addHistory(targets, scans, errs); histErr != nil && err == nil {
+		return histErr
+	}
+
+	return err
+}
+
+// scanTarget sends the scans to the target, in a single request when the target supports it.
+func scanTarget(target autoscan.Target, scans []autoscan.Scan) error {
+	if batcher, ok := target.(autoscan.BatchScanner); ok && len(scans) > 1 {
+		return batcher.ScanBatch(scans)
+	}
+
+	for _, scan := range scans {
+		if err := target.Scan(scan); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A HistoryEntry records the outcome of a Scan for a single Target.
+type HistoryEntry struct {
+	Folder    string    `json:"folder"`
+	Target    string    `json:"target"`
+	URL       string    `json:"url,omitempty"`
+	Libraries []string  `json:"libraries"`
+	DryRun    bool      `json:"dry_run"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+
+	// Verified is set by the verification of a processed scan.
+	Verified *bool `json:"verified,omitempty"`
+}
+
+// history is kept for a week
+const historyRetention = 7 * 24 * time.Hour
+
+func (p *Processor) addHistory(targets []autoscan.Target, scans []autoscan.Scan, errs []error) error {
+	entries := make([]HistoryEntry, 0, len(targets)*len(scans))
+
+	for _, scan := range scans {
+		entries = append(entries, historyEntries(targets, scan, errs)...)
+	}
+
+	return p.store.AddHistory(entries, historyRetention)
+}
+
+func historyEntries(targets []autoscan.Target, scan autoscan.Scan, errs []error) []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(targets))
+
+	for i, target := range targets {
+		e := autoscan.Explanation{
+			Target: fmt.Sprintf("%T", target),
+			Folder: scan.Folder,
+		}
+
+		if explainer, ok := target.(autoscan.Explainer); ok {
+			e = explainer.Explain(scan)
+		}
+
+		if errs[i] != nil {
+			e.Error = errs[i].Error()
+		}
+
+		entries = append(entries, HistoryEntry{
+			Folder:    scan.Folder,
+			Target:    e.Target,
+			URL:       e.URL,
+			Libraries: e.Libraries,
+			DryRun:    e.DryRun,
+			Error:     e.Error,
+			Time:      now(),
+		})
+	}
+
+	return entries
+}
+
+// History returns up to limit of the most recent history entries
+func (p *Processor) History(limit int) ([]HistoryEntry, error) {
+	return p.store.GetHistory(limit)
+}
+
+// DeliveryCompleted returns whether a webhook delivery with the idempotency key
+// has been completed within the window, in which case
+// the delivery is a duplicate and should not be processed again.
+func (p *Processor) DeliveryCompleted(key string, window time.Duration) (bool, error) {
+	return p.store.DeliveryCompleted(key, window)
+}
+
+// CompleteDelivery remembers the idempotency key of a successful webhook delivery.
+func (p *Processor) CompleteDelivery(key string) error {
+	return p.store.CompleteDelivery(key)
 }
 
 func (p *Processor) Process(targets []autoscan.Target) error {
-	scan, err := p.store.GetAvailableScan(p.minimumAge)
+	if p.escalate > 0 {
+		e, err := p.findEscalation(targets)
+		if err != nil {
+			return err
+		}
+
+		if e != nil {
+			if err := p.checkAnchors(); err != nil {
+				return err
+			}
+
+			return p.refreshLibrary(targets, *e)
+		}
+	}
+
+	scans, err := p.store.GetAvailableScans(p.minimumAge, p.batchSize)
 	if err != nil {
 		return err
 	}
 
-	// Check whether all anchors are present
-	for _, anchor := range p.anchors {
-		if !fileExists(anchor) {
-			return fmt.Errorf("%s: %w", anchor, autoscan.ErrAnchorUnavailable)
-		}
+	if err := p.checkAnchors(); err != nil {
+		return err
 	}
 
 	// Fatal or Target Unavailable -> return original error
-	err = p.callTargets(targets, scan)
+	err = p.callTargets(targets, scans)
 	if err != nil {
 		return err
 	}
 
-	err = p.store.Delete(scan)
-	if err != nil {
-		return err
+	for _, scan := range scans {
+		if err := p.store.Delete(scan); err != nil {
+			return err
+		}
+
+		atomic.AddInt64(&p.processed, 1)
+	}
+
+	return p.scheduleVerification(scans)
+}
+
+// checkAnchors checks whether all anchors are present
+func (p *Processor) checkAnchors() error {
+	for _, anchor := range p.anchors {
+		if !fileExists(anchor) {
+			return fmt.Errorf("%s: %w", anchor, autoscan.ErrAnchorUnavailable)
+		}
 	}
 
-	atomic.AddInt64(&p.processed, 1)
 	return nil
 }
 
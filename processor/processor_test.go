@@ -0,0 +1,817 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "TV", "Westworld"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	episode := filepath.Join(dir, "TV", "Westworld", "s01e01.mkv")
+	if err := os.WriteFile(episode, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type Test struct {
+		Name           string
+		Anchors        []string
+		FileScans      bool
+		DeletePriority *int
+		Scan           autoscan.Scan
+		WantQueued     string
+		WantEvent      autoscan.Event
+		WantPriority   int
+	}
+
+	deletePriority := 10
+
+	var testCases = []Test{
+		{
+			Name:       "Existence check is skipped without anchors",
+			Scan:       autoscan.Scan{Folder: "/does/not/exist", Path: "/exist"},
+			WantQueued: "/does/not/exist",
+		},
+		{
+			Name:       "Files fall back to their parent folder",
+			Anchors:    []string{episode},
+			Scan:       autoscan.Scan{Folder: episode, Path: "/TV/Westworld/s01e01.mkv"},
+			WantQueued: filepath.Dir(episode),
+			WantEvent:  autoscan.EventModified,
+		},
+		{
+			Name:         "Files are scanned as a modified folder without file scans",
+			Anchors:      []string{episode},
+			Scan:         autoscan.Scan{Folder: episode, Path: "/TV/Westworld/s01e01.mkv", Event: autoscan.EventCreated, Priority: 2},
+			WantQueued:   filepath.Dir(episode),
+			WantEvent:    autoscan.EventModified,
+			WantPriority: 2,
+		},
+		{
+			Name:       "Files keep their event with file scans",
+			Anchors:    []string{episode},
+			FileScans:  true,
+			Scan:       autoscan.Scan{Folder: episode, Path: "/TV/Westworld/s01e01.mkv", Event: autoscan.EventCreated},
+			WantQueued: filepath.Dir(episode),
+			WantEvent:  autoscan.EventCreated,
+		},
+		{
+			Name:           "Deletes use the delete priority",
+			DeletePriority: &deletePriority,
+			Scan:           autoscan.Scan{Folder: "/TV/Westworld", Event: autoscan.EventDeleted, Priority: 2},
+			WantQueued:     "/TV/Westworld",
+			WantEvent:      autoscan.EventDeleted,
+			WantPriority:   deletePriority,
+		},
+		{
+			Name:       "Folders which do not exist are not queued",
+			Anchors:    []string{episode},
+			Scan:       autoscan.Scan{Folder: filepath.Join(dir, "Movies"), Path: "/Movies"},
+			WantQueued: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			proc := &Processor{anchors: tc.Anchors, fileScans: tc.FileScans, deletePriority: tc.DeletePriority}
+
+			e := proc.Explain(tc.Scan)
+			if len(e.Scans) != 1 {
+				t.Fatalf("Expected one scan explanation, got %d", len(e.Scans))
+			}
+
+			if e.Scans[0].Queued != tc.WantQueued {
+				t.Errorf("Queued folders do not match: %q vs %q", e.Scans[0].Queued, tc.WantQueued)
+			}
+
+			if e.Scans[0].Event != tc.WantEvent {
+				t.Errorf("Events do not match: %q vs %q", e.Scans[0].Event, tc.WantEvent)
+			}
+
+			if e.Scans[0].Priority != tc.WantPriority {
+				t.Errorf("Priorities do not match: %d vs %d", e.Scans[0].Priority, tc.WantPriority)
+			}
+		})
+	}
+}
+
+func TestAddFileScans(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "TV", "Westworld"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	episodes := []string{
+		filepath.Join(dir, "TV", "Westworld", "s01e01.mkv"),
+		filepath.Join(dir, "TV", "Westworld", "s01e02.mkv"),
+	}
+
+	for _, episode := range episodes {
+		if err := os.WriteFile(episode, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type Test struct {
+		Name      string
+		FileScans bool
+		Scans     []autoscan.Scan
+		WantFile  string
+	}
+
+	var testCases = []Test{
+		{
+			Name:      "Files are kept with file scans",
+			FileScans: true,
+			Scans:     []autoscan.Scan{{Folder: episodes[0], Path: "/TV/Westworld/s01e01.mkv"}},
+			WantFile:  episodes[0],
+		},
+		{
+			Name:      "Files are dropped without file scans",
+			FileScans: false,
+			Scans:     []autoscan.Scan{{Folder: episodes[0], Path: "/TV/Westworld/s01e01.mkv"}},
+			WantFile:  "",
+		},
+		{
+			Name:      "Different files within a folder scan the whole folder",
+			FileScans: true,
+			Scans: []autoscan.Scan{
+				{Folder: episodes[0], Path: "/TV/Westworld/s01e01.mkv"},
+				{Folder: episodes[1], Path: "/TV/Westworld/s01e02.mkv"},
+			},
+			WantFile: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			proc := &Processor{
+				anchors:   []string{episodes[0]},
+				fileScans: tc.FileScans,
+				store:     getDatastore(t),
+			}
+
+			if err := proc.Add(tc.Scans...); err != nil {
+				t.Fatal(err)
+			}
+
+			scans, err := proc.store.GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(scans) != 1 {
+				t.Fatalf("Expected one queued scan, got %d", len(scans))
+			}
+
+			if scans[0].Folder != filepath.Dir(episodes[0]) {
+				t.Errorf("Folders do not match: %q vs %q", scans[0].Folder, filepath.Dir(episodes[0]))
+			}
+
+			if scans[0].File != tc.WantFile {
+				t.Errorf("Files do not match: %q vs %q", scans[0].File, tc.WantFile)
+			}
+		})
+	}
+}
+
+func TestAddDeletions(t *testing.T) {
+	dir := t.TempDir()
+	season := filepath.Join(dir, "TV", "Westworld", "Season 1")
+	if err := os.MkdirAll(season, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	anchor := filepath.Join(dir, "anchor")
+	if err := os.WriteFile(anchor, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deletePriority := 10
+
+	type Test struct {
+		Name      string
+		FileScans bool
+		Scan      autoscan.Scan
+		WantScan  autoscan.Scan
+	}
+
+	var testCases = []Test{
+		{
+			Name:      "Deleted files are scanned from their folder",
+			FileScans: true,
+			Scan: autoscan.Scan{
+				Folder: filepath.Join(season, "s01e01.mkv"),
+				Path:   "/TV/Westworld/Season 1/s01e01.mkv",
+				Event:  autoscan.EventDeleted,
+			},
+			WantScan: autoscan.Scan{
+				Folder:   season,
+				File:     filepath.Join(season, "s01e01.mkv"),
+				Event:    autoscan.EventDeleted,
+				Priority: deletePriority,
+			},
+		},
+		{
+			Name:      "Deleted folders are scanned from the closest existing parent",
+			FileScans: false,
+			Scan: autoscan.Scan{
+				Folder: filepath.Join(dir, "TV", "Legion", "Season 1"),
+				Path:   "/TV/Legion/Season 1",
+				Event:  autoscan.EventDeleted,
+			},
+			WantScan: autoscan.Scan{
+				Folder:   filepath.Join(dir, "TV"),
+				Event:    autoscan.EventModified,
+				Priority: deletePriority,
+			},
+		},
+		{
+			Name:      "Other events are not queued when the folder does not exist",
+			FileScans: true,
+			Scan: autoscan.Scan{
+				Folder: filepath.Join(dir, "TV", "Legion", "Season 1"),
+				Path:   "/TV/Legion/Season 1",
+				Event:  autoscan.EventCreated,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			proc := &Processor{
+				anchors:        []string{anchor},
+				fileScans:      tc.FileScans,
+				deletePriority: &deletePriority,
+				store:          getDatastore(t),
+			}
+
+			if err := proc.Add(tc.Scan); err != nil {
+				t.Fatal(err)
+			}
+
+			scans, err := proc.store.GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.WantScan.Folder == "" {
+				if len(scans) != 0 {
+					t.Errorf("Expected no queued scans, got %v", scans)
+				}
+
+				return
+			}
+
+			if len(scans) != 1 {
+				t.Fatalf("Expected one queued scan, got %d", len(scans))
+			}
+
+			if !reflect.DeepEqual(scans[0], tc.WantScan) {
+				t.Log(scans[0])
+				t.Log(tc.WantScan)
+				t.Errorf("Scans do not match")
+			}
+		})
+	}
+}
+
+type scanRecorder struct {
+	scans [][]autoscan.Scan
+}
+
+func (r *scanRecorder) Scan(scan autoscan.Scan) error {
+	r.scans = append(r.scans, []autoscan.Scan{scan})
+	return nil
+}
+
+func (r *scanRecorder) Available() error {
+	return nil
+}
+
+type batchRecorder struct {
+	scanRecorder
+}
+
+func (r *batchRecorder) ScanBatch(scans []autoscan.Scan) error {
+	r.scans = append(r.scans, scans)
+	return nil
+}
+
+func TestProcessBatch(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	proc := &Processor{
+		batchSize: 2,
+		store:     getDatastore(t),
+	}
+
+	err := proc.store.Upsert([]autoscan.Scan{
+		{Folder: "1", Priority: 3, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "2", Priority: 2, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "3", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single, batch := &scanRecorder{}, &batchRecorder{}
+	if err := proc.Process([]autoscan.Target{single, batch}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(single.scans) != 2 || len(single.scans[0]) != 1 || len(single.scans[1]) != 1 {
+		t.Errorf("Expected two single scans, got %v", single.scans)
+	}
+
+	if len(batch.scans) != 1 || len(batch.scans[0]) != 2 {
+		t.Fatalf("Expected one batch of two scans, got %v", batch.scans)
+	}
+
+	if batch.scans[0][0].Folder != "1" || batch.scans[0][1].Folder != "2" {
+		t.Errorf("Scans of the highest priority were not processed first: %v", batch.scans[0])
+	}
+
+	remaining, err := proc.store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 1 || remaining[0].Folder != "3" {
+		t.Errorf("Expected only the third scan to remain, got %v", remaining)
+	}
+
+	if proc.processed != 2 {
+		t.Errorf("Expected two processed scans, got %d", proc.processed)
+	}
+}
+
+type refreshRecorder struct {
+	scanRecorder
+	libraries []autoscan.Library
+	refreshed []autoscan.Library
+	matched   int
+}
+
+func (r *refreshRecorder) ScanLibraries(scan autoscan.Scan) []autoscan.Library {
+	r.matched++
+	for _, lib := range r.libraries {
+		if strings.HasPrefix(scan.Folder, lib.Path) {
+			return []autoscan.Library{lib}
+		}
+	}
+
+	return nil
+}
+
+func (r *refreshRecorder) RefreshLibrary(lib autoscan.Library) error {
+	r.refreshed = append(r.refreshed, lib)
+	return nil
+}
+
+type allRefreshRecorder struct {
+	refreshRecorder
+	refreshedAll int
+}
+
+func (r *allRefreshRecorder) RefreshAll() error {
+	r.refreshedAll++
+	return nil
+}
+
+func TestProcessEscalationRefreshAll(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	proc := &Processor{
+		batchSize: 1,
+		escalate:  3,
+		store:     getDatastore(t),
+	}
+
+	err := proc.store.Upsert([]autoscan.Scan{
+		{Folder: "/data/TV/Westworld/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/Westworld/Season 2", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/The Expanse/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresher := &refreshRecorder{libraries: []autoscan.Library{{ID: "1", Name: "TV", Path: "/data/TV/"}}}
+
+	// the scans are spread over two libraries of the other target
+	all := &allRefreshRecorder{refreshRecorder{libraries: []autoscan.Library{
+		{ID: "1", Name: "Westworld", Path: "/data/TV/Westworld/"},
+		{ID: "2", Name: "The Expanse", Path: "/data/TV/The Expanse/"},
+	}}, 0}
+
+	if err := proc.Process([]autoscan.Target{refresher, all}); err != nil {
+		t.Fatal(err)
+	}
+
+	if all.refreshedAll != 1 || len(all.refreshed) != 0 {
+		t.Errorf("Expected a single refresh of all libraries, got %d and %v", all.refreshedAll, all.refreshed)
+	}
+
+	history, err := proc.History(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 3 {
+		t.Errorf("Expected a history entry for every refreshed library, got %v", history)
+	}
+}
+
+func TestProcessEscalation(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	movies := autoscan.Library{ID: "1", Name: "Movies", Path: "/data/Movies/"}
+	tv := autoscan.Library{ID: "2", Name: "TV", Path: "/data/TV/"}
+
+	proc := &Processor{
+		batchSize: 1,
+		escalate:  3,
+		store:     getDatastore(t),
+	}
+
+	err := proc.store.Upsert([]autoscan.Scan{
+		{Folder: "/data/TV/Westworld/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/Westworld/Season 2", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/The Expanse/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/Movies/Interstellar (2014)", Priority: 5, Time: testTime.Add(-1 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresher := &refreshRecorder{libraries: []autoscan.Library{movies, tv}}
+	single := &scanRecorder{}
+
+	if err := proc.Process([]autoscan.Target{refresher, single}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(refresher.refreshed, []autoscan.Library{tv}) {
+		t.Errorf("Expected a refresh of the TV library, got %v", refresher.refreshed)
+	}
+
+	if len(refresher.scans) != 0 {
+		t.Errorf("Expected no scans to be sent to the refreshing target, got %v", refresher.scans)
+	}
+
+	if len(single.scans) != 3 {
+		t.Errorf("Expected the absorbed scans to be sent to other targets, got %v", single.scans)
+	}
+
+	remaining, err := proc.store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 1 || remaining[0].Folder != "/data/Movies/Interstellar (2014)" {
+		t.Errorf("Expected only the movie to remain, got %v", remaining)
+	}
+
+	// below the threshold, scans are processed one by one
+	if err := proc.Process([]autoscan.Target{refresher, single}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(refresher.refreshed) != 1 || len(refresher.scans) != 1 {
+		t.Errorf("Expected the movie to be scanned, got %v", refresher.scans)
+	}
+}
+
+func TestEscalationCheck(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	proc := &Processor{
+		batchSize: 1,
+		escalate:  3,
+		store:     getDatastore(t),
+	}
+
+	err := proc.store.Upsert([]autoscan.Scan{
+		{Folder: "/data/TV/Westworld/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/Westworld/Season 2", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+		{Folder: "/data/TV/The Expanse/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresher := &refreshRecorder{
+		libraries: []autoscan.Library{{ID: "1", Name: "Movies", Path: "/data/Movies/"}},
+	}
+
+	targets := []autoscan.Target{refresher}
+	if err := proc.Process(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	if refresher.matched != 3 {
+		t.Fatalf("Expected the queue to be matched once, got %d matches", refresher.matched)
+	}
+
+	// processing only removes scans, the queue is not matched again
+	if err := proc.Process(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	if refresher.matched != 3 {
+		t.Errorf("Expected no matches without changes to the queue, got %d matches", refresher.matched-3)
+	}
+
+	// added scans trigger another check
+	err = proc.Add(autoscan.Scan{Folder: "/data/Movies/Interstellar (2014)", Priority: 1, Time: testTime.Add(-1 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.Process(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	if refresher.matched != 5 {
+		t.Errorf("Expected the queue to be matched again after adding a scan, got %d matches", refresher.matched-3)
+	}
+}
+
+type verifyRecorder struct {
+	scanRecorder
+	missing []string
+}
+
+func (r *verifyRecorder) Verify(scan autoscan.Scan) ([]string, error) {
+	return r.missing, nil
+}
+
+func TestVerify(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	proc := &Processor{
+		batchSize: 1,
+		verify:    VerifyConfig{Delay: time.Minute},
+		store:     getDatastore(t),
+	}
+
+	scan := autoscan.Scan{Folder: "/data/TV/Westworld/Season 1", Priority: 1, Time: testTime.Add(-1 * time.Minute)}
+	if err := proc.store.Upsert([]autoscan.Scan{scan}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &verifyRecorder{missing: []string{"/data/TV/Westworld/Season 1/s01e01.mkv"}}
+	targets := []autoscan.Target{target}
+
+	if err := proc.Process(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	// not due yet
+	if err := proc.verifyScans(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.Process(targets); !errors.Is(err, autoscan.ErrNoScans) {
+		t.Fatalf("Expected no scans, got %v", err)
+	}
+
+	// missing after the first scan, the scan is queued once more
+	testTime = testTime.Add(2 * time.Minute)
+	if err := proc.verifyScans(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := proc.store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queued) != 1 || queued[0].Folder != scan.Folder {
+		t.Fatalf("Expected the scan to be retried, got %v", queued)
+	}
+
+	// the retry is processed and verified again, after which it is given up on
+	testTime = testTime.Add(time.Second)
+	if err := proc.Process(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	testTime = testTime.Add(2 * time.Minute)
+	if err := proc.verifyScans(targets); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proc.Process(targets); !errors.Is(err, autoscan.ErrNoScans) {
+		t.Fatalf("Expected no scans, got %v", err)
+	}
+
+	verifications, err := proc.store.GetDueVerifications(verifyLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(verifications) != 0 {
+		t.Errorf("Expected no verifications to remain, got %v", verifications)
+	}
+
+	history, err := proc.History(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := 0
+	for _, e := range history {
+		if e.Verified != nil {
+			verified++
+			if *e.Verified || e.Error == "" {
+				t.Errorf("Expected the scan to be missing: %+v", e)
+			}
+		}
+	}
+
+	if verified != 2 {
+		t.Errorf("Expected two verifications in history, got %d", verified)
+	}
+}
+
+type reconcileRecorder struct {
+	scanRecorder
+	missing map[string][]string
+}
+
+func (r *reconcileRecorder) Verify(scan autoscan.Scan) ([]string, error) {
+	return r.missing[scan.Folder], nil
+}
+
+func TestReconcile(t *testing.T) {
+	root := t.TempDir()
+	for _, folder := range []string{"Westworld/Season 1", "Westworld/Season 2", "The Expanse/Season 1"} {
+		if err := os.MkdirAll(filepath.Join(root, folder), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	westworld := filepath.Join(root, "Westworld")
+	target := &reconcileRecorder{
+		missing: map[string][]string{
+			westworld: {
+				filepath.Join(westworld, "Season 1", "s01e01.mkv"),
+				filepath.Join(westworld, "Season 1", "s01e02.mkv"),
+				filepath.Join(westworld, "Season 2", "s02e01.mkv"),
+			},
+		},
+	}
+
+	proc := &Processor{
+		store: getDatastore(t),
+	}
+
+	c := autoscan.ReconcileConfig{
+		Rate: 1000,
+	}
+
+	// roots which cannot be read are skipped
+	roots := []string{filepath.Join(root, "missing"), root}
+
+	queued, err := proc.reconcile(target, c, roots, make(map[string]*reconcileBackoff), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queued != 2 {
+		t.Errorf("Expected two queued scans, got %d", queued)
+	}
+
+	scans, err := proc.store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(scans, func(i, j int) bool {
+		return scans[i].Folder < scans[j].Folder
+	})
+
+	want := []string{filepath.Join(westworld, "Season 1"), filepath.Join(westworld, "Season 2")}
+	if len(scans) != len(want) {
+		t.Fatalf("Expected %d scans, got %v", len(want), scans)
+	}
+
+	for i, scan := range scans {
+		if scan.Folder != want[i] || scan.Priority != reconcilePriority {
+			t.Errorf("Unexpected scan: %+v", scan)
+		}
+	}
+}
+
+func TestReconcileBackoff(t *testing.T) {
+	testTime := time.Now().UTC()
+	now = func() time.Time {
+		return testTime
+	}
+
+	root := t.TempDir()
+	for _, folder := range []string{"Westworld", "The Expanse"} {
+		if err := os.MkdirAll(filepath.Join(root, folder), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	westworld := filepath.Join(root, "Westworld")
+	target := &reconcileRecorder{
+		missing: map[string][]string{
+			westworld: {filepath.Join(westworld, "s01e01.mkv")},
+		},
+	}
+
+	proc := &Processor{
+		store: getDatastore(t),
+	}
+
+	c := autoscan.ReconcileConfig{
+		Interval: time.Hour,
+		Rate:     1000,
+	}
+
+	backoff := make(map[string]*reconcileBackoff)
+	reconcile := func() int {
+		queued, err := proc.reconcile(target, c, []string{root}, backoff, zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return queued
+	}
+
+	// the folder is compared again after 2, 4, 8... intervals
+	var rescans []int
+	for i := 1; i <= 16; i++ {
+		if reconcile() > 0 {
+			rescans = append(rescans, i)
+		}
+
+		testTime = testTime.Add(c.Interval)
+	}
+
+	if expected := []int{1, 3, 7, 15}; !reflect.DeepEqual(rescans, expected) {
+		t.Errorf("Rescans do not match: %v vs %v (expected)", rescans, expected)
+	}
+
+	// the backoff is reset once the media is found
+	target.missing = nil
+	testTime = testTime.Add(16 * c.Interval)
+	if queued := reconcile(); queued != 0 {
+		t.Errorf("Expected no queued scans, got %d", queued)
+	}
+
+	if len(backoff) != 0 {
+		t.Errorf("Expected the backoff to be reset, got %v", backoff)
+	}
+
+	target.missing = map[string][]string{
+		westworld: {filepath.Join(westworld, "s01e01.mkv")},
+	}
+
+	if queued := reconcile(); queued != 1 {
+		t.Errorf("Expected a queued scan, got %d", queued)
+	}
+
+	// the backoff is limited
+	for i := 0; i < 10; i++ {
+		backoff[westworld].next = testTime
+		reconcile()
+	}
+
+	if b := backoff[westworld]; b.rescans != reconcileMaxBackoff || b.next != testTime.Add(c.Interval<<reconcileMaxBackoff) {
+		t.Errorf("Expected the backoff to be limited, got %+v", b)
+	}
+}
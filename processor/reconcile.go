@@ -0,0 +1,191 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// reconcilePriority is the priority of the scans queued by a reconciliation
+// when the target does not configure its own priority.
+const reconcilePriority = -1
+
+// reconcileAnchorWait is the time a reconciliation waits for the anchor files to be back.
+const reconcileAnchorWait = time.Minute
+
+// reconcileMaxBackoff limits the backoff of folders which are still missing media after
+// they were rescanned, to 2^reconcileMaxBackoff reconciliation intervals.
+// Media is reported missing when the target knows it under another title.
+const reconcileMaxBackoff = 5
+
+// A reconcileBackoff delays the next comparison of a folder which was rescanned before.
+type reconcileBackoff struct {
+	rescans int
+	next    time.Time
+}
+
+// Reconcile compares the media files within the library roots with the media files
+// known to the target on the interval, and queues scans for the folders of missing files.
+// Reconcile blocks and should be run in its own goroutine.
+func (p *Processor) Reconcile(target autoscan.Reconciler) {
+	c := target.Reconciliation()
+	if c.Interval <= 0 {
+		return
+	}
+
+	name := fmt.Sprintf("%T", target)
+	if explainer, ok := target.(autoscan.Explainer); ok {
+		name = explainer.Explain(autoscan.Scan{}).Target
+	}
+
+	l := log.With().
+		Str("target", name).
+		Logger()
+
+	backoff := make(map[string]*reconcileBackoff)
+
+	for {
+		time.Sleep(c.Interval)
+
+		roots := c.Paths
+		if len(roots) == 0 {
+			roots = target.LocalRoots()
+		}
+
+		start := time.Now()
+		queued, err := p.reconcile(target, c, roots, backoff, l)
+		if err != nil {
+			l.Error().
+				Err(err).
+				Msg("Reconciliation failed")
+
+			continue
+		}
+
+		l.Info().
+			Int("queued", queued).
+			Stringer("duration", time.Since(start).Round(time.Second)).
+			Msg("Reconciliation finished")
+	}
+}
+
+// reconcile compares every folder within the library roots, throttled by the rate.
+// Folders which were rescanned before are compared again after their backoff.
+// It returns the number of queued scans.
+func (p *Processor) reconcile(target autoscan.Verifier, c autoscan.ReconcileConfig, roots []string, backoff map[string]*reconcileBackoff, l zerolog.Logger) (int, error) {
+	priority := reconcilePriority
+	if c.Priority != nil {
+		priority = *c.Priority
+	}
+
+	limit := rate.Limit(c.Rate)
+	if c.Rate <= 0 {
+		limit = 1
+	}
+
+	limiter := rate.NewLimiter(limit, 1)
+	queued := 0
+
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			l.Warn().
+				Err(err).
+				Str("path", root).
+				Msg("Failed reading library root")
+
+			continue
+		}
+
+		for _, entry := range entries {
+			folder := filepath.Join(root, entry.Name())
+			if b, ok := backoff[folder]; ok && now().Before(b.next) {
+				continue
+			}
+
+			if err := limiter.Wait(context.Background()); err != nil {
+				return queued, err
+			}
+
+			// a missing mount looks like missing media
+			for p.checkAnchors() != nil {
+				l.Warn().Msg("Anchor files unavailable, reconciliation paused")
+				time.Sleep(reconcileAnchorWait)
+			}
+
+			missing, err := target.Verify(autoscan.Scan{Folder: folder})
+			if err != nil {
+				l.Warn().
+					Err(err).
+					Str("path", folder).
+					Msg("Failed comparing folder")
+
+				continue
+			}
+
+			scans := missingScans(missing, priority)
+			if len(scans) == 0 {
+				delete(backoff, folder)
+				continue
+			}
+
+			b, ok := backoff[folder]
+			if !ok {
+				b = &reconcileBackoff{}
+				backoff[folder] = b
+			}
+
+			l.Info().
+				Str("path", folder).
+				Int("missing", len(missing)).
+				Int("rescans", b.rescans).
+				Msg("Queueing scans for missing media")
+
+			if err := p.Add(scans...); err != nil {
+				return queued, err
+			}
+
+			// compare the folder again after 2, 4, 8... intervals
+			if b.rescans < reconcileMaxBackoff {
+				b.rescans++
+			}
+
+			b.next = now().Add(c.Interval << b.rescans)
+
+			queued += len(scans)
+		}
+	}
+
+	return queued, nil
+}
+
+// missingScans returns a scan for every folder holding missing files.
+func missingScans(missing []string, priority int) []autoscan.Scan {
+	seen := make(map[string]bool)
+	scans := make([]autoscan.Scan, 0)
+
+	for _, file := range missing {
+		folder := filepath.Dir(file)
+		if seen[folder] {
+			continue
+		}
+
+		seen[folder] = true
+		scans = append(scans, autoscan.Scan{
+			Folder:   folder,
+			Priority: priority,
+			Time:     now(),
+			Event:    autoscan.EventCreated,
+		})
+	}
+
+	return scans
+}
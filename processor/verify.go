@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// A VerifyConfig enables the verification of processed scans.
+type VerifyConfig struct {
+	// Delay is the time between processing a scan and verifying it.
+	// Verification is disabled when not set.
+	Delay time.Duration `yaml:"delay"`
+
+	// AlertURL receives a JSON POST request for every scan which is still missing after the retry.
+	AlertURL string `yaml:"alert-url"`
+}
+
+// verifyLimit is the maximum number of scans verified at once.
+const verifyLimit = 10
+
+// verifyInterval is the time between checks for scans which are due for verification.
+const verifyInterval = time.Minute
+
+// retryGrace is the time a verification waits for its retried scan to be processed.
+// Verifications of scans which never get processed again are alerted after this time.
+const retryGrace = 24 * time.Hour
+
+// alertClient sends the alerts, its timeout keeps a hanging endpoint from piling up requests.
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// An Alert is sent when a scan is still missing from a target after the retry.
+type Alert struct {
+	Folder  string   `json:"folder"`
+	Target  string   `json:"target"`
+	URL     string   `json:"url,omitempty"`
+	Missing []string `json:"missing"`
+}
+
+// scheduleVerification verifies the scans after the delay.
+// Deletions are not verified.
+func (p *Processor) scheduleVerification(scans []autoscan.Scan) error {
+	if p.verify.Delay <= 0 {
+		return nil
+	}
+
+	verify := make([]autoscan.Scan, 0, len(scans))
+	for _, scan := range scans {
+		if scan.Event != autoscan.EventDeleted {
+			verify = append(verify, scan)
+		}
+	}
+
+	if len(verify) == 0 {
+		return nil
+	}
+
+	return p.store.AddVerifications(verify, now().Add(p.verify.Delay))
+}
+
+// Verify periodically verifies the processed scans which are due.
+// It runs next to the processing loop, so slow targets do not hold up the scans,
+// and only returns when verification is disabled.
+func (p *Processor) Verify(targets []autoscan.Target) {
+	if p.verify.Delay <= 0 {
+		return
+	}
+
+	for {
+		time.Sleep(verifyInterval)
+
+		if err := p.verifyScans(targets); err != nil {
+			log.Error().
+				Err(err).
+				Msg("Verification failed")
+		}
+	}
+}
+
+// verifyScans verifies the scans which are due on every target which supports it.
+// Missing scans are queued once more, and alerted when they are still missing after that.
+func (p *Processor) verifyScans(targets []autoscan.Target) error {
+	for {
+		verifications, err := p.store.GetDueVerifications(verifyLimit)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range verifications {
+			if err := p.verifyScan(targets, v); err != nil {
+				return err
+			}
+		}
+
+		if len(verifications) < verifyLimit {
+			return nil
+		}
+	}
+}
+
+func (p *Processor) verifyScan(targets []autoscan.Target, v verification) error {
+	l := log.With().
+		Str("path", v.Scan.Folder).
+		Int("attempt", v.Attempt).
+		Logger()
+
+	entries := make([]HistoryEntry, 0, len(targets))
+	alerts := make([]Alert, 0)
+
+	for _, target := range targets {
+		verifier, ok := target.(autoscan.Verifier)
+		if !ok {
+			continue
+		}
+
+		missing, err := verifier.Verify(v.Scan)
+		if err != nil {
+			// the target may be unavailable, try again after the delay
+			l.Warn().
+				Err(err).
+				Msg("Failed verifying scan")
+
+			return p.store.RescheduleVerification(v.Scan.Folder, v.Attempt, now().Add(p.verify.Delay))
+		}
+
+		e := autoscan.Explanation{Target: fmt.Sprintf("%T", target)}
+		if explainer, ok := target.(autoscan.Explainer); ok {
+			e = explainer.Explain(v.Scan)
+		}
+
+		verified := len(missing) == 0
+		entry := HistoryEntry{
+			Folder:    v.Scan.Folder,
+			Target:    e.Target,
+			URL:       e.URL,
+			Libraries: e.Libraries,
+			Time:      now(),
+			Verified:  &verified,
+		}
+
+		if !verified {
+			entry.Error = fmt.Sprintf("missing after scan: %s", strings.Join(missing, ", "))
+			alerts = append(alerts, Alert{
+				Folder:  v.Scan.Folder,
+				Target:  e.Target,
+				URL:     e.URL,
+				Missing: missing,
+			})
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := p.store.AddHistory(entries, historyRetention); err != nil {
+		return err
+	}
+
+	switch {
+	case len(alerts) == 0:
+		l.Debug().Msg("Scan verified")
+		return p.store.DeleteVerification(v.Scan.Folder)
+
+	case v.Attempt == 0:
+		l.Warn().
+			Int("targets", len(alerts)).
+			Msg("Scan missing after scan, retrying")
+
+		scan := v.Scan
+		scan.Time = now()
+		err := p.insert([]autoscan.Scan{scan})
+		switch {
+		case errors.Is(err, autoscan.ErrQueueFull):
+			// retry once the queue has room again
+			l.Warn().
+				Err(err).
+				Msg("Queue is full, retrying scan later")
+
+			return p.store.RescheduleVerification(v.Scan.Folder, v.Attempt, now().Add(p.verify.Delay))
+		case err != nil:
+			return err
+		}
+
+		return p.store.RescheduleVerification(v.Scan.Folder, 1, now().Add(retryGrace))
+	}
+
+	for _, alert := range alerts {
+		l.Error().
+			Str("target", alert.Target).
+			Strs("missing", alert.Missing).
+			Msg("Scan still missing after retry")
+
+		// sent in the background, so a slow endpoint does not hold up processing
+		go func(alert Alert) {
+			if err := p.sendAlert(alert); err != nil {
+				l.Error().
+					Err(err).
+					Msg("Failed sending alert")
+			}
+		}(alert)
+	}
+
+	return p.store.DeleteVerification(v.Scan.Folder)
+}
+
+func (p *Processor) sendAlert(alert Alert) error {
+	if p.verify.AlertURL == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	res, err := alertClient.Post(p.verify.AlertURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("alert: %s", res.Status)
+	}
+
+	return nil
+}
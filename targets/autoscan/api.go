@@ -77,7 +77,8 @@ func (c apiClient) Available() error {
 	return nil
 }
 
-func (c apiClient) Scan(path string) error {
+// Scan sends all paths to the manual trigger in a single request.
+func (c apiClient) Scan(paths ...string) error {
 	// create request
 	req, err := http.NewRequest("POST", autoscan.JoinURL(c.baseURL, "triggers", "manual"), nil)
 	if err != nil {
@@ -89,7 +90,9 @@ func (c apiClient) Scan(path string) error {
 	}
 
 	q := url.Values{}
-	q.Add("dir", path)
+	for _, path := range paths {
+		q.Add("dir", path)
+	}
 	req.URL.RawQuery = q.Encode()
 
 	// send request
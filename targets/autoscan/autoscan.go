@@ -12,6 +12,7 @@ type Config struct {
 	Pass      string             `yaml:"password"`
 	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
 	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
 }
 
 type target struct {
@@ -46,16 +47,24 @@ func New(c Config) (autoscan.Target, error) {
 }
 
 func (t target) Scan(scan autoscan.Scan) error {
-	scanFolder := t.rewrite(scan.Folder)
+	return t.ScanBatch([]autoscan.Scan{scan})
+}
+
+// ScanBatch forwards the scans in a single request.
+func (t target) ScanBatch(scans []autoscan.Scan) error {
+	folders := make([]string, 0, len(scans))
+	for _, scan := range scans {
+		folders = append(folders, t.rewrite(scan.Folder))
+	}
 
 	// send scan request
 	l := t.log.With().
-		Str("path", scanFolder).
+		Strs("paths", folders).
 		Logger()
 
 	l.Trace().Msg("Sending scan request")
 
-	if err := t.api.Scan(scanFolder); err != nil {
+	if err := t.api.Scan(folders...); err != nil {
 		return err
 	}
 
@@ -63,6 +72,14 @@ func (t target) Scan(scan autoscan.Scan) error {
 	return nil
 }
 
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	return autoscan.Explanation{
+		Target: "autoscan",
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	}
+}
+
 func (t target) Available() error {
 	return t.api.Available()
 }
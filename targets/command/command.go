@@ -0,0 +1,268 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type Config struct {
+	Command   string             `yaml:"command"`
+	Args      []string           `yaml:"args"`
+	Timeout   time.Duration      `yaml:"timeout"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// Concurrency is the number of commands run at the same time for the scans of a batch.
+	// Scans are sent one batch at a time, so it has no effect with a batch size of 1.
+	Concurrency int `yaml:"concurrency"`
+
+	// Retry are the exit codes which mark the target as unavailable, so the scan is retried later.
+	// Fatal are the exit codes which stop the processor.
+	// The scan is dropped after any other exit code.
+	// A command which times out exits with timeoutExitCode.
+	Retry []int `yaml:"retry-exit-codes"`
+	Fatal []int `yaml:"fatal-exit-codes"`
+}
+
+// defaultTimeout is the maximum run time of the command when not configured.
+const defaultTimeout = time.Minute
+
+// defaultRetry is the exit code which marks the target as unavailable when not configured,
+// EX_TEMPFAIL of sysexits.h.
+const defaultRetry = 75
+
+// timeoutExitCode is the exit code of a command which timed out,
+// the exit code of the timeout command of coreutils.
+const timeoutExitCode = 124
+
+// maxOutput is the maximum number of bytes of stdout and stderr which are logged.
+const maxOutput = 64 << 10
+
+type target struct {
+	command     string
+	args        []string
+	timeout     time.Duration
+	retry       map[int]bool
+	fatal       map[int]bool
+	concurrency int
+
+	log     zerolog.Logger
+	rewrite autoscan.Rewriter
+}
+
+func New(c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", "exec").
+		Str("command", c.Command).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Command == "" {
+		return nil, fmt.Errorf("exec command is missing: %w", autoscan.ErrFatal)
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+
+	if c.Concurrency <= 0 {
+		c.Concurrency = 1
+	}
+
+	if len(c.Retry) == 0 {
+		c.Retry = []int{defaultRetry}
+	}
+
+	retry := make(map[int]bool, len(c.Retry))
+	for _, code := range c.Retry {
+		retry[code] = true
+	}
+
+	fatal := make(map[int]bool, len(c.Fatal))
+	for _, code := range c.Fatal {
+		if retry[code] {
+			return nil, fmt.Errorf("exec exit code %d is both retried and fatal: %w", code, autoscan.ErrFatal)
+		}
+
+		fatal[code] = true
+	}
+
+	return &target{
+		command:     c.Command,
+		args:        c.Args,
+		timeout:     c.Timeout,
+		retry:       retry,
+		fatal:       fatal,
+		concurrency: c.Concurrency,
+
+		log:     l,
+		rewrite: rewriter,
+	}, nil
+}
+
+func (t target) Available() error {
+	if _, err := exec.LookPath(t.command); err != nil {
+		return fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	return nil
+}
+
+// Scan runs the command with the folder and the priority of the scan as its last arguments.
+// The fields of the scan are available as environment variables as well.
+func (t target) Scan(scan autoscan.Scan) error {
+	folder := t.rewrite(scan.Folder)
+	file := ""
+	if scan.File != "" {
+		file = t.rewrite(scan.File)
+	}
+
+	priority := strconv.Itoa(scan.Priority)
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	args := append(append([]string{}, t.args...), folder, priority)
+	cmd := exec.CommandContext(ctx, t.command, args...)
+	cmd.Env = append(os.Environ(),
+		"AUTOSCAN_FOLDER="+folder,
+		"AUTOSCAN_FILE="+file,
+		"AUTOSCAN_PRIORITY="+priority,
+		"AUTOSCAN_EVENT="+string(scan.Event),
+		"AUTOSCAN_TIME="+scan.Time.Format(time.RFC3339),
+	)
+
+	stdout, stderr := &limitedBuffer{max: maxOutput}, &limitedBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	l := t.log.With().
+		Str("path", folder).
+		Logger()
+
+	l.Trace().Msg("Running command")
+
+	start := time.Now()
+	err := cmd.Run()
+
+	logOutput(l.Debug, "stdout", stdout)
+	logOutput(l.Warn, "stderr", stderr)
+
+	var exitErr *exec.ExitError
+	code := 0
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		l.Warn().
+			Dur("timeout", t.timeout).
+			Msg("Command timed out")
+
+		code = timeoutExitCode
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		return fmt.Errorf("command: %v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	switch {
+	case code == 0:
+	case t.retry[code]:
+		return fmt.Errorf("command exited with %d: %w", code, autoscan.ErrTargetUnavailable)
+	case t.fatal[code]:
+		return fmt.Errorf("command exited with %d: %w", code, autoscan.ErrFatal)
+	default:
+		// a failing script should not stop the processor for all targets
+		l.Error().
+			Int("exit_code", code).
+			Msg("Command failed, dropping scan")
+
+		return nil
+	}
+
+	l.Info().
+		Dur("duration", time.Since(start)).
+		Msg("Scan moved to target")
+
+	return nil
+}
+
+// ScanBatch runs the command for every scan of the batch,
+// with at most the configured concurrency of commands at once.
+func (t target) ScanBatch(scans []autoscan.Scan) error {
+	g := new(errgroup.Group)
+	g.SetLimit(t.concurrency)
+
+	for _, scan := range scans {
+		scan := scan
+		g.Go(func() error {
+			return t.Scan(scan)
+		})
+	}
+
+	return g.Wait()
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	e := autoscan.Explanation{
+		Target: "exec",
+		URL:    t.command,
+		Folder: t.rewrite(scan.Folder),
+	}
+
+	if scan.File != "" {
+		e.File = t.rewrite(scan.File)
+	}
+
+	return e
+}
+
+// logOutput logs every line of the output of the command.
+func logOutput(level func() *zerolog.Event, stream string, output *limitedBuffer) {
+	scanner := bufio.NewScanner(&output.buf)
+	for scanner.Scan() {
+		level().
+			Str("stream", stream).
+			Msg(scanner.Text())
+	}
+
+	if output.truncated {
+		level().
+			Str("stream", stream).
+			Msg("Output truncated")
+	}
+}
+
+// A limitedBuffer keeps the first max bytes written to it and discards the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.max - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+
+		return len(p), nil
+	}
+
+	return b.buf.Write(p)
+}
@@ -0,0 +1,190 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// script writes its arguments and the environment of the scan to the out file next to it,
+// and exits with the exit code in EXIT or sleeps when it is "sleep".
+const script = `#!/bin/sh
+out="$(dirname "$0")/out"
+printf '%s\n' "$@" > "$out"
+env | grep '^AUTOSCAN_' | sort >> "$out"
+echo "scanning"
+echo "warning" >&2
+if [ "$EXIT" = "sleep" ]; then
+	exec sleep 10
+fi
+exit "$EXIT"
+`
+
+func writeScript(t *testing.T) string {
+	dir := t.TempDir()
+	command := filepath.Join(dir, "scan.sh")
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return command
+}
+
+func TestScan(t *testing.T) {
+	type Test struct {
+		Name     string
+		Exit     string
+		Config   Config
+		Expected error
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Succeeds",
+			Exit:     "0",
+			Expected: nil,
+		},
+		{
+			Name:     "Retries the default exit code",
+			Exit:     "75",
+			Expected: autoscan.ErrTargetUnavailable,
+		},
+		{
+			Name:     "Retries the configured exit codes",
+			Exit:     "3",
+			Config:   Config{Retry: []int{3}},
+			Expected: autoscan.ErrTargetUnavailable,
+		},
+		{
+			Name:     "Stops on the fatal exit codes",
+			Exit:     "4",
+			Config:   Config{Fatal: []int{4}},
+			Expected: autoscan.ErrFatal,
+		},
+		{
+			Name:     "Drops the scan after other exit codes",
+			Exit:     "1",
+			Config:   Config{Fatal: []int{4}},
+			Expected: nil,
+		},
+		{
+			Name:     "Drops the scan after a timeout",
+			Exit:     "sleep",
+			Config:   Config{Timeout: 200 * time.Millisecond},
+			Expected: nil,
+		},
+		{
+			Name:     "Handles a timeout like its exit code",
+			Exit:     "sleep",
+			Config:   Config{Timeout: 200 * time.Millisecond, Fatal: []int{timeoutExitCode}},
+			Expected: autoscan.ErrFatal,
+		},
+		{
+			Name:     "Retries a missing command",
+			Config:   Config{Command: "/does/not/exist"},
+			Expected: autoscan.ErrTargetUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			c := tc.Config
+			if c.Command == "" {
+				c.Command = writeScript(t)
+			}
+
+			t.Setenv("EXIT", tc.Exit)
+
+			target, err := New(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = target.Scan(autoscan.Scan{Folder: "/data/TV/Westworld"})
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestScanArguments(t *testing.T) {
+	command := writeScript(t)
+	t.Setenv("EXIT", "0")
+
+	target, err := New(Config{
+		Command: command,
+		Args:    []string{"--refresh"},
+		Rewrite: []autoscan.Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := autoscan.Scan{
+		Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 1",
+		File:     "/mnt/unionfs/Media/TV/Westworld/Season 1/s01e01.mkv",
+		Priority: 5,
+		Event:    autoscan.EventCreated,
+		Time:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	if err := target.Scan(scan); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(filepath.Join(filepath.Dir(command), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"--refresh",
+		"/data/TV/Westworld/Season 1",
+		"5",
+		"AUTOSCAN_EVENT=created",
+		"AUTOSCAN_FILE=/data/TV/Westworld/Season 1/s01e01.mkv",
+		"AUTOSCAN_FOLDER=/data/TV/Westworld/Season 1",
+		"AUTOSCAN_PRIORITY=5",
+		"AUTOSCAN_TIME=2020-01-02T03:04:05Z",
+	}
+
+	if got := strings.Split(strings.TrimSpace(string(out)), "\n"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Output does not match:\n%q\nvs (expected)\n%q", got, expected)
+	}
+}
+
+func TestScanBatch(t *testing.T) {
+	command := writeScript(t)
+	t.Setenv("EXIT", "1")
+
+	target, err := New(Config{
+		Command:     command,
+		Concurrency: 2,
+		Retry:       []int{1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scans := []autoscan.Scan{{Folder: "/data/TV/Westworld"}, {Folder: "/data/TV/The Expanse"}}
+	if err := target.(autoscan.BatchScanner).ScanBatch(scans); !errors.Is(err, autoscan.ErrTargetUnavailable) {
+		t.Errorf("Expected the batch to be retried, got %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := New(Config{}); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected a missing command to be fatal, got %v", err)
+	}
+
+	if _, err := New(Config{Command: "true", Retry: []int{1}, Fatal: []int{1}}); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected an exit code which is both retried and fatal to be fatal, got %v", err)
+	}
+}
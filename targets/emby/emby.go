@@ -1,103 +1,62 @@
 package emby
 
 import (
-	"fmt"
-	"strings"
-
-	"github.com/rs/zerolog"
+	"encoding/json"
+	"io"
 
 	"github.com/cloudbox/autoscan"
+	"github.com/cloudbox/autoscan/targets/mediabrowser"
 )
 
-type Config struct {
-	URL       string             `yaml:"url"`
-	Token     string             `yaml:"token"`
-	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
-	Verbosity string             `yaml:"verbosity"`
-}
-
-type target struct {
-	url       string
-	token     string
-	libraries []library
+type Config = mediabrowser.Config
 
-	log     zerolog.Logger
-	rewrite autoscan.Rewriter
-	api     apiClient
+var server = mediabrowser.Server{
+	Name:          "emby",
+	InfoPath:      []string{"emby", "System", "Info"},
+	LibrariesPath: []string{"emby", "Library", "SelectableMediaFolders"},
+	ItemsPath:     []string{"emby", "Items"},
+	Libraries:     libraries,
+	UpdateType:    updateType,
 }
 
 func New(c Config) (autoscan.Target, error) {
-	l := autoscan.GetLogger(c.Verbosity).With().
-		Str("target", "emby").
-		Str("url", c.URL).
-		Logger()
+	return mediabrowser.New(server, c)
+}
 
-	rewriter, err := autoscan.NewRewriter(c.Rewrite)
-	if err != nil {
-		return nil, err
+func libraries(r io.Reader) ([]autoscan.Library, error) {
+	type Response struct {
+		ID      string `json:"Id"`
+		Name    string `json:"Name"`
+		Folders []struct {
+			Path string `json:"Path"`
+		} `json:"SubFolders"`
 	}
 
-	api := newAPIClient(c.URL, c.Token, l)
-
-	libraries, err := api.Libraries()
-	if err != nil {
+	resp := make([]Response, 0)
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
 		return nil, err
 	}
 
-	l.Debug().
-		Interface("libraries", libraries).
-		Msg("Retrieved libraries")
-
-	return &target{
-		url:       c.URL,
-		token:     c.Token,
-		libraries: libraries,
-
-		log:     l,
-		rewrite: rewriter,
-		api:     api,
-	}, nil
-}
-
-func (t target) Available() error {
-	return t.api.Available()
-}
-
-func (t target) Scan(scan autoscan.Scan) error {
-	// determine library for this scan
-	scanFolder := t.rewrite(scan.Folder)
-
-	lib, err := t.getScanLibrary(scanFolder)
-	if err != nil {
-		t.log.Warn().
-			Err(err).
-			Msg("No target libraries found")
-
-		return nil
-	}
-
-	l := t.log.With().
-		Str("path", scanFolder).
-		Str("library", lib.Name).
-		Logger()
-
-	// send scan request
-	l.Trace().Msg("Sending scan request")
-
-	if err := t.api.Scan(scanFolder); err != nil {
-		return err
+	libraries := make([]autoscan.Library, 0)
+	for _, lib := range resp {
+		for _, folder := range lib.Folders {
+			libraries = append(libraries, autoscan.Library{
+				ID:   lib.ID,
+				Name: lib.Name,
+				Path: folder.Path,
+			})
+		}
 	}
 
-	l.Info().Msg("Scan moved to target")
-	return nil
+	return libraries, nil
 }
 
-func (t target) getScanLibrary(folder string) (*library, error) {
-	for _, l := range t.libraries {
-		if strings.HasPrefix(folder, l.Path) {
-			return &l, nil
-		}
+// updateType returns the type of change understood by the Media/Updated endpoint.
+// Other changes are sent as Created, as Emby handles those by scanning the path.
+func updateType(event autoscan.Event) string {
+	if event == autoscan.EventDeleted {
+		return "Deleted"
 	}
 
-	return nil, fmt.Errorf("%v: failed determining library", folder)
+	return "Created"
 }
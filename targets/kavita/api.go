@@ -1,10 +1,11 @@
-package jellyfin
+package kavita
 
 import (
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/rs/zerolog"
 
@@ -15,22 +16,19 @@ type apiClient struct {
 	client  *http.Client
 	log     zerolog.Logger
 	baseURL string
-	token   string
+	apiKey  string
 }
 
-func newAPIClient(baseURL string, token string, log zerolog.Logger) apiClient {
+func newAPIClient(baseURL string, apiKey string, log zerolog.Logger) apiClient {
 	return apiClient{
 		client:  &http.Client{},
 		log:     log,
 		baseURL: baseURL,
-		token:   token,
+		apiKey:  apiKey,
 	}
 }
 
 func (c apiClient) do(req *http.Request) (*http.Response, error) {
-	req.Header.Set("X-Emby-Token", c.token)
-	req.Header.Set("Accept", "application/json") // Force JSON Response.
-
 	res, err := c.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
@@ -50,7 +48,7 @@ func (c apiClient) do(req *http.Request) (*http.Response, error) {
 
 	switch res.StatusCode {
 	case 401:
-		return nil, fmt.Errorf("invalid jellyfin token: %s: %w", res.Status, autoscan.ErrFatal)
+		return nil, fmt.Errorf("invalid kavita api key: %s: %w", res.Status, autoscan.ErrFatal)
 	case 404, 500, 502, 503, 504:
 		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
 	default:
@@ -59,103 +57,104 @@ func (c apiClient) do(req *http.Request) (*http.Response, error) {
 }
 
 func (c apiClient) Available() error {
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "System", "Info")
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "health")
 	req, err := http.NewRequest("GET", reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed creating availability request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// send request
 	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("availability: %w", err)
 	}
 
-	defer res.Body.Close()
+	res.Body.Close()
 	return nil
 }
 
-type library struct {
-	Name string
-	Path string
-}
-
-func (c apiClient) Libraries() ([]library, error) {
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "Library", "VirtualFolders")
-	req, err := http.NewRequest("GET", reqURL, nil)
+// authenticate exchanges the API key for a token.
+func (c apiClient) authenticate() (string, error) {
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "Plugin", "authenticate")
+	req, err := http.NewRequest("POST", reqURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating libraries request: %v: %w", err, autoscan.ErrFatal)
+		return "", fmt.Errorf("failed creating authentication request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// send request
+	q := url.Values{}
+	q.Add("apiKey", c.apiKey)
+	q.Add("pluginName", "autoscan")
+	req.URL.RawQuery = q.Encode()
+
 	res, err := c.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("libraries: %w", err)
+		return "", fmt.Errorf("authenticate: %w", err)
 	}
 
 	defer res.Body.Close()
 
-	// decode response
 	type Response struct {
-		Name      string   `json:"Name"`
-		Locations []string `json:"Locations"`
+		Token string `json:"token"`
 	}
 
-	resp := make([]Response, 0)
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return nil, fmt.Errorf("failed decoding libraries request response: %v: %w", err, autoscan.ErrFatal)
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return "", fmt.Errorf("failed decoding authentication request response: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// process response
-	libraries := make([]library, 0)
-	for _, lib := range resp {
-		for _, folder := range lib.Locations {
-			libPath := folder
+	return resp.Token, nil
+}
 
-			// Add trailing slash if there is none.
-			if len(libPath) > 0 && libPath[len(libPath)-1] != '/' {
-				libPath += "/"
-			}
+type library struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Folders []string `json:"folders"`
+}
 
-			libraries = append(libraries, library{
-				Name: lib.Name,
-				Path: libPath,
-			})
-		}
+func (c apiClient) Libraries() ([]library, error) {
+	token, err := c.authenticate()
+	if err != nil {
+		return nil, err
 	}
 
-	return libraries, nil
-}
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "Library", "libraries")
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating libraries request: %v: %w", err, autoscan.ErrFatal)
+	}
 
-type scanRequest struct {
-	Path       string `json:"path"`
-	UpdateType string `json:"updateType"`
-}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-func (c apiClient) Scan(path string) error {
-	// create request payload
-	type Payload struct {
-		Updates []scanRequest `json:"Updates"`
+	res, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("libraries: %w", err)
 	}
 
-	payload := &Payload{
-		Updates: []scanRequest{
-			{
-				Path:       path,
-				UpdateType: "Modified",
-			},
-		},
+	defer res.Body.Close()
+
+	libraries := make([]library, 0)
+	if err := json.NewDecoder(res.Body).Decode(&libraries); err != nil {
+		return nil, fmt.Errorf("failed decoding libraries request response: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	b, err := json.Marshal(payload)
+	return libraries, nil
+}
+
+// Scan scans the folder within the library it belongs to.
+func (c apiClient) Scan(folder string) error {
+	type Request struct {
+		APIKey     string `json:"apiKey"`
+		FolderPath string `json:"folderPath"`
+	}
+
+	b, err := json.Marshal(Request{
+		APIKey:     c.apiKey,
+		FolderPath: folder,
+	})
 	if err != nil {
-		return fmt.Errorf("failed encoding scan request payload: %v: %w", err, autoscan.ErrFatal)
+		return fmt.Errorf("failed encoding scan request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "Library", "Media", "Updated")
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "Library", "scan-folder")
 	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(b))
 	if err != nil {
 		return fmt.Errorf("failed creating scan request: %v: %w", err, autoscan.ErrFatal)
@@ -163,12 +162,11 @@ func (c apiClient) Scan(path string) error {
 
 	req.Header.Set("Content-Type", "application/json")
 
-	// send request
 	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("scan: %w", err)
 	}
 
-	defer res.Body.Close()
+	res.Body.Close()
 	return nil
 }
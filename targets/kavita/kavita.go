@@ -0,0 +1,140 @@
+package kavita
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type Config struct {
+	URL       string             `yaml:"url"`
+	APIKey    string             `yaml:"api-key"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// LibraryRefresh is the interval at which the libraries are retrieved again.
+	LibraryRefresh time.Duration `yaml:"library-refresh"`
+
+	// Libraries determines how scans are matched to libraries.
+	Libraries autoscan.LibraryConfig `yaml:"libraries"`
+}
+
+type target struct {
+	url       string
+	libraries *autoscan.LibraryCache
+
+	log     zerolog.Logger
+	rewrite autoscan.Rewriter
+	api     apiClient
+}
+
+func New(c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", "kavita").
+		Str("url", c.URL).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &target{
+		url: c.URL,
+
+		log:     l,
+		rewrite: rewriter,
+		api:     newAPIClient(c.URL, c.APIKey, l),
+	}
+
+	t.libraries, err = autoscan.NewLibraryCache(t.fetchLibraries, c.LibraryRefresh, c.Libraries, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t target) Available() error {
+	return t.api.Available()
+}
+
+func (t target) Scan(scan autoscan.Scan) error {
+	// determine library for this scan.
+	// Kavita scans series folders, so file scans are sent as the folder of the file.
+	scanFolder := t.rewrite(scan.Folder)
+
+	libs, err := t.libraries.MatchScan(scanFolder)
+	if err != nil {
+		t.log.Warn().
+			Err(err).
+			Msg("No target libraries found")
+
+		return nil
+	}
+
+	// libraries sharing a folder only need to be scanned once
+	scanned := make(map[string]bool)
+
+	// send scan request
+	for _, lib := range libs {
+		if scanned[lib.Folder] {
+			continue
+		}
+
+		scanned[lib.Folder] = true
+
+		l := t.log.With().
+			Str("path", lib.Folder).
+			Str("library", lib.Library.Name).
+			Logger()
+
+		l.Trace().Msg("Sending scan request")
+
+		if err := t.api.Scan(lib.Folder); err != nil {
+			return err
+		}
+
+		l.Info().Msg("Scan moved to target")
+	}
+
+	return nil
+}
+
+// Libraries retrieves the libraries and refreshes the libraries used to match scans.
+func (t target) Libraries() ([]autoscan.Library, error) {
+	return t.libraries.Refresh()
+}
+
+func (t target) fetchLibraries() ([]autoscan.Library, error) {
+	libs, err := t.api.Libraries()
+	if err != nil {
+		return nil, err
+	}
+
+	// a library can have multiple folders, each of which is matched on its own
+	libraries := make([]autoscan.Library, 0, len(libs))
+	for _, lib := range libs {
+		for _, folder := range lib.Folders {
+			libraries = append(libraries, autoscan.Library{
+				ID:   strconv.Itoa(lib.ID),
+				Name: lib.Name,
+				Path: folder,
+			})
+		}
+	}
+
+	return libraries, nil
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	return t.libraries.Explain(autoscan.Explanation{
+		Target: "kavita",
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	})
+}
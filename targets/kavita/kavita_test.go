@@ -0,0 +1,203 @@
+package kavita
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// fakeServer exchanges the API key for a token, serves the libraries
+// and records the folders it scans.
+type fakeServer struct {
+	libraries []library
+
+	mu     sync.Mutex
+	status int
+	scans  []string
+}
+
+const (
+	testAPIKey = "key"
+	testToken  = "token"
+)
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != 0 {
+		rw.WriteHeader(s.status)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/health":
+	case r.Method == "POST" && r.URL.Path == "/api/Plugin/authenticate":
+		if r.URL.Query().Get("apiKey") != testAPIKey {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(rw).Encode(map[string]string{"token": testToken})
+	case r.Method == "GET" && r.URL.Path == "/api/Library/libraries":
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(rw).Encode(s.libraries)
+	case r.Method == "POST" && r.URL.Path == "/api/Library/scan-folder":
+		req := struct {
+			APIKey     string `json:"apiKey"`
+			FolderPath string `json:"folderPath"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.APIKey != testAPIKey {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.scans = append(s.scans, req.FolderPath)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer, c Config) autoscan.Target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	c.URL = srv.URL
+	c.APIKey = testAPIKey
+	c.Rewrite = []autoscan.Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}}
+
+	target, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+var testLibraries = []library{
+	{ID: 1, Name: "Comics", Folders: []string{"/data/Comics", "/data/Manga"}},
+	{ID: 2, Name: "Books", Folders: []string{"/data/Books"}},
+	{ID: 3, Name: "Everything", Folders: []string{"/data"}},
+}
+
+func TestScan(t *testing.T) {
+	type Test struct {
+		Name     string
+		Config   Config
+		Folder   string
+		Expected []string
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Scans the folder within its library",
+			Folder:   "/mnt/unionfs/Media/Books/Dune",
+			Expected: []string{"/data/Books/Dune"},
+		},
+		{
+			Name:     "Matches every folder of a library",
+			Folder:   "/mnt/unionfs/Media/Manga/Berserk",
+			Expected: []string{"/data/Manga/Berserk"},
+		},
+		{
+			Name:     "Scans a folder shared by libraries once",
+			Config:   Config{Libraries: autoscan.LibraryConfig{Match: autoscan.LibraryMatchAll}},
+			Folder:   "/mnt/unionfs/Media/Books/Dune",
+			Expected: []string{"/data/Books/Dune"},
+		},
+		{
+			Name:   "Ignores folders outside of the libraries",
+			Config: Config{Libraries: autoscan.LibraryConfig{Exclude: []string{"Everything"}}},
+			Folder: "/mnt/unionfs/Media/Music/Queen",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{libraries: testLibraries}
+			target := newTestTarget(t, fs, tc.Config)
+
+			if err := target.Scan(autoscan.Scan{Folder: tc.Folder}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(fs.scans, tc.Expected) {
+				t.Errorf("Scanned folders do not match: %v vs %v (expected)", fs.scans, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	fs := &fakeServer{libraries: testLibraries}
+	target := newTestTarget(t, fs, Config{})
+
+	libraries, err := target.(autoscan.LibraryLister).Libraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []autoscan.Library{
+		{ID: "1", Name: "Comics", Path: "/data/Comics"},
+		{ID: "1", Name: "Comics", Path: "/data/Manga"},
+		{ID: "2", Name: "Books", Path: "/data/Books"},
+		{ID: "3", Name: "Everything", Path: "/data"},
+	}
+
+	if !reflect.DeepEqual(libraries, expected) {
+		t.Errorf("Libraries do not match: %v vs %v (expected)", libraries, expected)
+	}
+
+	e := target.(autoscan.Explainer).Explain(autoscan.Scan{Folder: "/mnt/unionfs/Media/Manga/Berserk"})
+	if !reflect.DeepEqual(e.Libraries, []string{"Comics"}) || e.Error != "" {
+		t.Errorf("Unexpected explanation: %+v", e)
+	}
+
+	e = target.(autoscan.Explainer).Explain(autoscan.Scan{Folder: "/mnt/Music/Queen"})
+	if len(e.Libraries) != 0 || e.Error == "" {
+		t.Errorf("Expected the folder to not match any library: %+v", e)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	type Test struct {
+		Name     string
+		Status   int
+		Expected error
+	}
+
+	var testCases = []Test{
+		{"Available", 0, nil},
+		{"Invalid API key", http.StatusUnauthorized, autoscan.ErrFatal},
+		{"Not found", http.StatusNotFound, autoscan.ErrTargetUnavailable},
+		{"Unavailable", http.StatusServiceUnavailable, autoscan.ErrTargetUnavailable},
+		{"Bad request", http.StatusBadRequest, autoscan.ErrFatal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{}
+			target := newTestTarget(t, fs, Config{})
+
+			fs.mu.Lock()
+			fs.status = tc.Status
+			fs.mu.Unlock()
+
+			err := target.Available()
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package kodi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type apiClient struct {
+	client  *http.Client
+	log     zerolog.Logger
+	baseURL string
+	user    string
+	pass    string
+}
+
+func newAPIClient(baseURL string, user string, pass string, log zerolog.Logger) apiClient {
+	return apiClient{
+		client:  &http.Client{},
+		log:     log,
+		baseURL: baseURL,
+		user:    user,
+		pass:    pass,
+	}
+}
+
+func (c apiClient) do(req *http.Request) (*http.Response, error) {
+	if c.user != "" || c.pass != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	c.log.Trace().
+		Stringer("request_url", res.Request.URL).
+		Int("response_status", res.StatusCode).
+		Msg("Request failed")
+
+	// statusCode not in the 2xx range, close response
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 401:
+		return nil, fmt.Errorf("invalid kodi credentials: %s: %w", res.Status, autoscan.ErrFatal)
+	case 404, 500, 502, 503, 504:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
+	default:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrFatal)
+	}
+}
+
+// call sends a JSON-RPC request and decodes its result into v.
+func (c apiClient) call(method string, params interface{}, v interface{}) error {
+	type Request struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      int         `json:"id"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+	}
+
+	b, err := json.Marshal(Request{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return fmt.Errorf("failed encoding %s request: %v: %w", method, err, autoscan.ErrFatal)
+	}
+
+	req, err := http.NewRequest("POST", autoscan.JoinURL(c.baseURL, "jsonrpc"), bytes.NewBuffer(b))
+	if err != nil {
+		return fmt.Errorf("failed creating %s request: %v: %w", method, err, autoscan.ErrFatal)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed decoding %s response: %v: %w", method, err, autoscan.ErrFatal)
+	}
+
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s (%d): %w", method, resp.Error.Message, resp.Error.Code, autoscan.ErrFatal)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp.Result, v); err != nil {
+		return fmt.Errorf("failed decoding %s result: %v: %w", method, err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+func (c apiClient) Available() error {
+	pong := ""
+	if err := c.call("JSONRPC.Ping", nil, &pong); err != nil {
+		return fmt.Errorf("availability: %w", err)
+	}
+
+	return nil
+}
+
+// Media types of Kodi sources.
+const (
+	mediaVideo = "video"
+	mediaMusic = "music"
+)
+
+type source struct {
+	Media string
+	Name  string
+	Path  string
+}
+
+func (c apiClient) Sources() ([]source, error) {
+	sources := make([]source, 0)
+
+	for _, media := range []string{mediaVideo, mediaMusic} {
+		type Result struct {
+			Sources []struct {
+				File  string `json:"file"`
+				Label string `json:"label"`
+			} `json:"sources"`
+		}
+
+		result := new(Result)
+		if err := c.call("Files.GetSources", map[string]string{"media": media}, result); err != nil {
+			return nil, fmt.Errorf("sources: %w", err)
+		}
+
+		for _, s := range result.Sources {
+			sources = append(sources, source{
+				Media: media,
+				Name:  s.Label,
+				Path:  withTrailingSeparator(s.File),
+			})
+		}
+	}
+
+	return sources, nil
+}
+
+// Scan scans the directory in the video or the music library.
+func (c apiClient) Scan(media string, directory string) error {
+	method := "VideoLibrary.Scan"
+	if media == mediaMusic {
+		method = "AudioLibrary.Scan"
+	}
+
+	// Kodi only recognises directories with a trailing separator
+	params := map[string]interface{}{
+		"directory":   withTrailingSeparator(directory),
+		"showdialogs": false,
+	}
+
+	if err := c.call(method, params, nil); err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	return nil
+}
+
+// withTrailingSeparator adds a trailing slash, or a backslash for Windows paths, if there is none.
+func withTrailingSeparator(path string) string {
+	if path == "" || strings.HasSuffix(path, "/") || strings.HasSuffix(path, `\`) {
+		return path
+	}
+
+	if strings.Contains(path, `\`) {
+		return path + `\`
+	}
+
+	return path + "/"
+}
@@ -0,0 +1,131 @@
+package kodi
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type Config struct {
+	URL       string             `yaml:"url"`
+	User      string             `yaml:"username"`
+	Pass      string             `yaml:"password"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// LibraryRefresh is the interval at which the sources are retrieved again.
+	LibraryRefresh time.Duration `yaml:"library-refresh"`
+
+	// Libraries determines how scans are matched to sources.
+	Libraries autoscan.LibraryConfig `yaml:"libraries"`
+}
+
+type target struct {
+	url       string
+	libraries *autoscan.LibraryCache
+
+	log     zerolog.Logger
+	rewrite autoscan.Rewriter
+	api     apiClient
+}
+
+func New(c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", "kodi").
+		Str("url", c.URL).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &target{
+		url: c.URL,
+
+		log:     l,
+		rewrite: rewriter,
+		api:     newAPIClient(c.URL, c.User, c.Pass, l),
+	}
+
+	t.libraries, err = autoscan.NewLibraryCache(t.fetchLibraries, c.LibraryRefresh, c.Libraries, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t target) Available() error {
+	return t.api.Available()
+}
+
+func (t target) Scan(scan autoscan.Scan) error {
+	// determine source for this scan.
+	// Kodi only scans directories, so file scans are sent as the folder of the file.
+	scanFolder := t.rewrite(scan.Folder)
+
+	libs, err := t.libraries.MatchScan(scanFolder)
+	if err != nil {
+		t.log.Warn().
+			Err(err).
+			Msg("No target libraries found")
+
+		return nil
+	}
+
+	// send scan request
+	for _, lib := range libs {
+		l := t.log.With().
+			Str("path", lib.Folder).
+			Str("library", lib.Library.Name).
+			Logger()
+
+		l.Trace().Msg("Sending scan request")
+
+		if err := t.api.Scan(lib.Library.ID, lib.Folder); err != nil {
+			return err
+		}
+
+		l.Info().Msg("Scan moved to target")
+	}
+
+	return nil
+}
+
+// Libraries retrieves the sources and refreshes the sources used to match scans.
+func (t target) Libraries() ([]autoscan.Library, error) {
+	return t.libraries.Refresh()
+}
+
+// fetchLibraries maps the sources of Kodi to libraries.
+// The ID of a library is the media type of the source, video or music,
+// as Kodi does not identify its sources otherwise.
+func (t target) fetchLibraries() ([]autoscan.Library, error) {
+	sources, err := t.api.Sources()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]autoscan.Library, 0, len(sources))
+	for _, s := range sources {
+		libraries = append(libraries, autoscan.Library{
+			ID:   s.Media,
+			Name: s.Name,
+			Path: s.Path,
+		})
+	}
+
+	return libraries, nil
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	return t.libraries.Explain(autoscan.Explanation{
+		Target: "kodi",
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	})
+}
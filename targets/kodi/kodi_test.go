@@ -0,0 +1,215 @@
+package kodi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type scanRequest struct {
+	Method    string
+	Directory string
+}
+
+// fakeServer implements the JSON-RPC methods used by the target
+// and records the scan requests it receives.
+type fakeServer struct {
+	sources map[string][]string // paths of the sources by media type
+
+	mu     sync.Mutex
+	status int
+	fail   bool // respond with a JSON-RPC error
+	scans  []scanRequest
+}
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "kodi" || pass != "secret" {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if s.status != 0 {
+		rw.WriteHeader(s.status)
+		return
+	}
+
+	req := struct {
+		Method string `json:"method"`
+		Params struct {
+			Media     string `json:"media"`
+			Directory string `json:"directory"`
+		} `json:"params"`
+	}{}
+
+	if r.URL.Path != "/jsonrpc" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	respond := func(result interface{}) {
+		json.NewEncoder(rw).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}
+
+	if s.fail {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"error":   map[string]interface{}{"code": -32601, "message": "Method not found."},
+		})
+		return
+	}
+
+	switch req.Method {
+	case "JSONRPC.Ping":
+		respond("pong")
+	case "Files.GetSources":
+		sources := make([]map[string]string, 0)
+		for _, path := range s.sources[req.Params.Media] {
+			sources = append(sources, map[string]string{"file": path, "label": req.Params.Media + " " + path})
+		}
+
+		respond(map[string]interface{}{"sources": sources})
+	case "VideoLibrary.Scan", "AudioLibrary.Scan":
+		s.scans = append(s.scans, scanRequest{req.Method, req.Params.Directory})
+		respond("OK")
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer) autoscan.Target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	target, err := New(Config{
+		URL:     srv.URL,
+		User:    "kodi",
+		Pass:    "secret",
+		Rewrite: []autoscan.Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func TestScan(t *testing.T) {
+	type Test struct {
+		Name     string
+		Folder   string
+		Expected []scanRequest
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Scans video sources in the video library",
+			Folder:   "/mnt/unionfs/Media/Movies/Interstellar (2014)",
+			Expected: []scanRequest{{"VideoLibrary.Scan", "/data/Movies/Interstellar (2014)/"}},
+		},
+		{
+			Name:     "Scans music sources in the audio library",
+			Folder:   "/mnt/unionfs/Media/Music/Queen",
+			Expected: []scanRequest{{"AudioLibrary.Scan", "/data/Music/Queen/"}},
+		},
+		{
+			Name:   "Ignores scans outside of the sources",
+			Folder: "/mnt/unionfs/Media/Books/Dune",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{
+				sources: map[string][]string{
+					mediaVideo: {"/data/Movies"},
+					mediaMusic: {"/data/Music/"},
+				},
+			}
+
+			target := newTestTarget(t, fs)
+			if err := target.Scan(autoscan.Scan{Folder: tc.Folder}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(fs.scans, tc.Expected) {
+				t.Errorf("Scans do not match: %v vs %v (expected)", fs.scans, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	fs := &fakeServer{
+		sources: map[string][]string{
+			mediaVideo: {"/data/Movies", `D:\Media\TV`},
+			mediaMusic: {"/data/Music/"},
+		},
+	}
+
+	target := newTestTarget(t, fs)
+
+	libraries, err := target.(autoscan.LibraryLister).Libraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []autoscan.Library{
+		{ID: mediaVideo, Name: "video /data/Movies", Path: "/data/Movies/"},
+		{ID: mediaVideo, Name: `video D:\Media\TV`, Path: `D:\Media\TV\`},
+		{ID: mediaMusic, Name: "music /data/Music/", Path: "/data/Music/"},
+	}
+
+	if !reflect.DeepEqual(libraries, expected) {
+		t.Errorf("Libraries do not match: %v vs %v (expected)", libraries, expected)
+	}
+
+	e := target.(autoscan.Explainer).Explain(autoscan.Scan{Folder: "/mnt/unionfs/Media/Books/Dune"})
+	if e.Folder != "/data/Books/Dune" || e.Error == "" {
+		t.Errorf("Expected the folder to not match any source: %+v", e)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	type Test struct {
+		Name     string
+		Status   int
+		Fail     bool
+		Expected error
+	}
+
+	var testCases = []Test{
+		{"Available", 0, false, nil},
+		{"Not found", http.StatusNotFound, false, autoscan.ErrTargetUnavailable},
+		{"Unavailable", http.StatusServiceUnavailable, false, autoscan.ErrTargetUnavailable},
+		{"Bad request", http.StatusBadRequest, false, autoscan.ErrFatal},
+		{"Invalid credentials", http.StatusUnauthorized, false, autoscan.ErrFatal},
+		{"JSON-RPC error", 0, true, autoscan.ErrFatal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{}
+			target := newTestTarget(t, fs)
+
+			fs.mu.Lock()
+			fs.status = tc.Status
+			fs.fail = tc.Fail
+			fs.mu.Unlock()
+
+			err := target.Available()
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
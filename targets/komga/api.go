@@ -1,7 +1,6 @@
-package emby
+package komga
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -15,21 +14,30 @@ type apiClient struct {
 	client  *http.Client
 	log     zerolog.Logger
 	baseURL string
-	token   string
+	user    string
+	pass    string
+	apiKey  string
 }
 
-func newAPIClient(baseURL string, token string, log zerolog.Logger) apiClient {
+func newAPIClient(baseURL string, user string, pass string, apiKey string, log zerolog.Logger) apiClient {
 	return apiClient{
 		client:  &http.Client{},
 		log:     log,
 		baseURL: baseURL,
-		token:   token,
+		user:    user,
+		pass:    pass,
+		apiKey:  apiKey,
 	}
 }
 
 func (c apiClient) do(req *http.Request) (*http.Response, error) {
-	req.Header.Set("X-Emby-Token", c.token)
-	req.Header.Set("Accept", "application/json") // Force JSON Response.
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	req.Header.Set("Accept", "application/json")
 
 	res, err := c.client.Do(req)
 	if err != nil {
@@ -50,7 +58,7 @@ func (c apiClient) do(req *http.Request) (*http.Response, error) {
 
 	switch res.StatusCode {
 	case 401:
-		return nil, fmt.Errorf("invalid emby token: %s: %w", res.Status, autoscan.ErrFatal)
+		return nil, fmt.Errorf("invalid komga credentials: %s: %w", res.Status, autoscan.ErrFatal)
 	case 404, 500, 502, 503, 504:
 		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
 	default:
@@ -59,37 +67,34 @@ func (c apiClient) do(req *http.Request) (*http.Response, error) {
 }
 
 func (c apiClient) Available() error {
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "emby", "System", "Info")
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "v2", "users", "me")
 	req, err := http.NewRequest("GET", reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed creating availability request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// send request
 	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("availability: %w", err)
 	}
 
-	defer res.Body.Close()
+	res.Body.Close()
 	return nil
 }
 
 type library struct {
-	Name string
-	Path string
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Root string `json:"root"`
 }
 
 func (c apiClient) Libraries() ([]library, error) {
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "emby", "Library", "SelectableMediaFolders")
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "v1", "libraries")
 	req, err := http.NewRequest("GET", reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating libraries request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	// send request
 	res, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("libraries: %w", err)
@@ -97,80 +102,27 @@ func (c apiClient) Libraries() ([]library, error) {
 
 	defer res.Body.Close()
 
-	// decode response
-	type Response struct {
-		Name    string `json:"Name"`
-		Folders []struct {
-			Path string `json:"Path"`
-		} `json:"SubFolders"`
-	}
-
-	resp := make([]Response, 0)
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return nil, fmt.Errorf("failed decoding libraries request response: %v: %w", err, autoscan.ErrFatal)
-	}
-
-	// process response
 	libraries := make([]library, 0)
-	for _, lib := range resp {
-		for _, folder := range lib.Folders {
-			libPath := folder.Path
-
-			// Add trailing slash if there is none.
-			if len(libPath) > 0 && libPath[len(libPath)-1] != '/' {
-				libPath += "/"
-			}
-
-			libraries = append(libraries, library{
-				Name: lib.Name,
-				Path: libPath,
-			})
-		}
+	if err := json.NewDecoder(res.Body).Decode(&libraries); err != nil {
+		return nil, fmt.Errorf("failed decoding libraries request response: %v: %w", err, autoscan.ErrFatal)
 	}
 
 	return libraries, nil
 }
 
-type scanRequest struct {
-	Path       string `json:"path"`
-	UpdateType string `json:"updateType"`
-}
-
-func (c apiClient) Scan(path string) error {
-	// create request payload
-	type Payload struct {
-		Updates []scanRequest `json:"Updates"`
-	}
-
-	payload := &Payload{
-		Updates: []scanRequest{
-			{
-				Path:       path,
-				UpdateType: "Created",
-			},
-		},
-	}
-
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed encoding scan request payload: %v: %w", err, autoscan.ErrFatal)
-	}
-
-	// create request
-	reqURL := autoscan.JoinURL(c.baseURL, "Library", "Media", "Updated")
-	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(b))
+// Scan scans the whole library, Komga does not scan single folders.
+func (c apiClient) Scan(libraryID string) error {
+	reqURL := autoscan.JoinURL(c.baseURL, "api", "v1", "libraries", libraryID, "scan")
+	req, err := http.NewRequest("POST", reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed creating scan request: %v: %w", err, autoscan.ErrFatal)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	// send request
 	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("scan: %w", err)
 	}
 
-	defer res.Body.Close()
+	res.Body.Close()
 	return nil
 }
@@ -0,0 +1,192 @@
+package komga
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// fakeServer serves the libraries and records the libraries it scans.
+type fakeServer struct {
+	libraries []library
+	apiKey    string
+
+	mu     sync.Mutex
+	status int
+	scans  []string
+}
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, pass, _ := r.BasicAuth()
+	if r.Header.Get("X-API-Key") != s.apiKey && (user != "komga" || pass != "secret") {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if s.status != 0 {
+		rw.WriteHeader(s.status)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v2/users/me":
+		json.NewEncoder(rw).Encode(map[string]string{"email": "komga@domain.tld"})
+	case r.Method == "GET" && r.URL.Path == "/api/v1/libraries":
+		json.NewEncoder(rw).Encode(s.libraries)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/scan"):
+		s.scans = append(s.scans, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/libraries/"), "/scan"))
+		rw.WriteHeader(http.StatusAccepted)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer, c Config) autoscan.Target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	c.URL = srv.URL
+	if c.APIKey == "" {
+		c.User, c.Pass = "komga", "secret"
+	}
+
+	c.Rewrite = []autoscan.Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}}
+
+	target, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+var testLibraries = []library{
+	{ID: "0A1", Name: "Comics", Root: "/data/Comics"},
+	{ID: "0B2", Name: "Books", Root: "/data/Books"},
+}
+
+func TestScanBatch(t *testing.T) {
+	type Test struct {
+		Name     string
+		Config   Config
+		Scans    []autoscan.Scan
+		Expected []string
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Scans the library of the folder",
+			Scans:    []autoscan.Scan{{Folder: "/mnt/unionfs/Media/Books/Dune"}},
+			Expected: []string{"0B2"},
+		},
+		{
+			Name:     "Authenticates with an API key",
+			Config:   Config{APIKey: "key"},
+			Scans:    []autoscan.Scan{{Folder: "/mnt/unionfs/Media/Books/Dune"}},
+			Expected: []string{"0B2"},
+		},
+		{
+			Name: "Scans every library of the batch once",
+			Scans: []autoscan.Scan{
+				{Folder: "/mnt/unionfs/Media/Books/Dune"},
+				{Folder: "/mnt/unionfs/Media/Comics/Saga"},
+				{Folder: "/mnt/unionfs/Media/Books/Foundation"},
+			},
+			Expected: []string{"0B2", "0A1"},
+		},
+		{
+			Name: "Skips folders outside of the libraries",
+			Scans: []autoscan.Scan{
+				{Folder: "/mnt/unionfs/Media/Books 4K/Dune"},
+				{Folder: "/mnt/unionfs/Media/Comics/Saga"},
+			},
+			Expected: []string{"0A1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{libraries: testLibraries, apiKey: tc.Config.APIKey}
+			target := newTestTarget(t, fs, tc.Config)
+
+			if err := target.(autoscan.BatchScanner).ScanBatch(tc.Scans); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(fs.scans, tc.Expected) {
+				t.Errorf("Scanned libraries do not match: %v vs %v (expected)", fs.scans, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	fs := &fakeServer{libraries: testLibraries}
+	target := newTestTarget(t, fs, Config{})
+
+	libraries, err := target.(autoscan.LibraryLister).Libraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []autoscan.Library{
+		{ID: "0A1", Name: "Comics", Path: "/data/Comics"},
+		{ID: "0B2", Name: "Books", Path: "/data/Books"},
+	}
+
+	if !reflect.DeepEqual(libraries, expected) {
+		t.Errorf("Libraries do not match: %v vs %v (expected)", libraries, expected)
+	}
+
+	e := target.(autoscan.Explainer).Explain(autoscan.Scan{Folder: "/mnt/unionfs/Media/Books/Dune"})
+	if !reflect.DeepEqual(e.Libraries, []string{"Books"}) || e.Error != "" {
+		t.Errorf("Unexpected explanation: %+v", e)
+	}
+
+	e = target.(autoscan.Explainer).Explain(autoscan.Scan{Folder: "/mnt/unionfs/Media/Music/Queen"})
+	if len(e.Libraries) != 0 || e.Error == "" {
+		t.Errorf("Expected the folder to not match any library: %+v", e)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	type Test struct {
+		Name     string
+		Status   int
+		Expected error
+	}
+
+	var testCases = []Test{
+		{"Available", 0, nil},
+		{"Invalid credentials", http.StatusUnauthorized, autoscan.ErrFatal},
+		{"Not found", http.StatusNotFound, autoscan.ErrTargetUnavailable},
+		{"Unavailable", http.StatusServiceUnavailable, autoscan.ErrTargetUnavailable},
+		{"Bad request", http.StatusBadRequest, autoscan.ErrFatal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{}
+			target := newTestTarget(t, fs, Config{})
+
+			fs.mu.Lock()
+			fs.status = tc.Status
+			fs.mu.Unlock()
+
+			err := target.Available()
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
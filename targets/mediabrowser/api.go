@@ -0,0 +1,263 @@
+package mediabrowser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type apiClient struct {
+	client  *http.Client
+	log     zerolog.Logger
+	server  Server
+	baseURL string
+	token   string
+}
+
+func newAPIClient(server Server, baseURL string, token string, log zerolog.Logger) apiClient {
+	return apiClient{
+		client:  &http.Client{},
+		log:     log,
+		server:  server,
+		baseURL: baseURL,
+		token:   token,
+	}
+}
+
+func (c apiClient) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Emby-Token", c.token)
+	req.Header.Set("Accept", "application/json") // Force JSON Response.
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	c.log.Trace().
+		Stringer("request_url", res.Request.URL).
+		Int("response_status", res.StatusCode).
+		Msg("Request failed")
+
+	// statusCode not in the 2xx range, close response
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 401:
+		return nil, fmt.Errorf("invalid %s token: %s: %w", c.server.Name, res.Status, autoscan.ErrFatal)
+	case 404, 500, 502, 503, 504:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
+	default:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrFatal)
+	}
+}
+
+func (c apiClient) Available() error {
+	// create request
+	reqURL := autoscan.JoinURL(c.baseURL, c.server.InfoPath...)
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating availability request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	// send request
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("availability: %w", err)
+	}
+
+	defer res.Body.Close()
+	return nil
+}
+
+func (c apiClient) Libraries() ([]autoscan.Library, error) {
+	// create request
+	reqURL := autoscan.JoinURL(c.baseURL, c.server.LibrariesPath...)
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating libraries request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	// send request
+	res, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("libraries: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	// decode response
+	libraries, err := c.server.Libraries(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding libraries request response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	for i, lib := range libraries {
+		// Add trailing slash if there is none.
+		if len(lib.Path) > 0 && lib.Path[len(lib.Path)-1] != '/' {
+			libraries[i].Path += "/"
+		}
+	}
+
+	return libraries, nil
+}
+
+type scanRequest struct {
+	Path       string `json:"path"`
+	UpdateType string `json:"updateType"`
+}
+
+// Scan sends all updates in a single request.
+func (c apiClient) Scan(updates []scanRequest) error {
+	// create request payload
+	type Payload struct {
+		Updates []scanRequest `json:"Updates"`
+	}
+
+	payload := &Payload{
+		Updates: updates,
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed encoding scan request payload: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	// create request
+	reqURL := autoscan.JoinURL(c.baseURL, "Library", "Media", "Updated")
+	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(b))
+	if err != nil {
+		return fmt.Errorf("failed creating scan request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// send request
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	defer res.Body.Close()
+	return nil
+}
+
+type part struct {
+	File string
+	Size int64
+}
+
+// Parts returns the files of the movies and episodes of shows with the given title.
+func (c apiClient) Parts(title string) ([]part, error) {
+	q := url.Values{}
+	q.Add("Recursive", "true")
+	q.Add("SearchTerm", title)
+	q.Add("IncludeItemTypes", "Movie,Series")
+	q.Add("Fields", "MediaSources")
+
+	items, err := c.items(q)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]part, 0)
+	for _, i := range items {
+		if i.Type != "Series" {
+			parts = append(parts, i.parts()...)
+			continue
+		}
+
+		// shows hold their files in their episodes
+		q := url.Values{}
+		q.Add("Recursive", "true")
+		q.Add("ParentId", i.ID)
+		q.Add("IncludeItemTypes", "Episode")
+		q.Add("Fields", "MediaSources")
+
+		episodes, err := c.items(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range episodes {
+			parts = append(parts, e.parts()...)
+		}
+	}
+
+	return parts, nil
+}
+
+type item struct {
+	ID           string `json:"Id"`
+	Type         string `json:"Type"`
+	MediaSources []struct {
+		Path string `json:"Path"`
+		Size int64  `json:"Size"`
+	} `json:"MediaSources"`
+}
+
+func (i item) parts() []part {
+	parts := make([]part, 0, len(i.MediaSources))
+	for _, source := range i.MediaSources {
+		parts = append(parts, part{
+			File: source.Path,
+			Size: source.Size,
+		})
+	}
+
+	return parts
+}
+
+func (c apiClient) items(q url.Values) ([]item, error) {
+	reqURL := autoscan.JoinURL(c.baseURL, c.server.ItemsPath...)
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating search request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		Items []item `json:"Items"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed decoding search response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	return resp.Items, nil
+}
+
+// RefreshLibraries scans all libraries, as the server does not refresh a single library through this endpoint.
+func (c apiClient) RefreshLibraries() error {
+	reqURL := autoscan.JoinURL(c.baseURL, "Library", "Refresh")
+	req, err := http.NewRequest("POST", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating refresh request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+
+	res.Body.Close()
+	return nil
+}
@@ -0,0 +1,339 @@
+// Package mediabrowser implements the targets of Emby and Jellyfin,
+// which share most of their API since Jellyfin was forked from Emby.
+package mediabrowser
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// Server holds what differs between the APIs of Emby and Jellyfin.
+type Server struct {
+	// Name identifies the server in logs, errors and explanations.
+	Name string
+
+	// InfoPath, LibrariesPath and ItemsPath are the paths of the endpoints below the URL.
+	InfoPath      []string
+	LibrariesPath []string
+	ItemsPath     []string
+
+	// Libraries decodes the response of the libraries endpoint.
+	Libraries func(r io.Reader) ([]autoscan.Library, error)
+
+	// UpdateType returns the type of change understood by the Media/Updated endpoint.
+	UpdateType func(event autoscan.Event) string
+}
+
+type Config struct {
+	URL       string             `yaml:"url"`
+	Token     string             `yaml:"token"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// LibraryRefresh is the interval at which the libraries are retrieved again.
+	LibraryRefresh time.Duration `yaml:"library-refresh"`
+
+	// Libraries determines how scans are matched to libraries.
+	Libraries autoscan.LibraryConfig `yaml:"libraries"`
+
+	// Reconcile compares the media files on disk with the libraries on a schedule.
+	Reconcile autoscan.ReconcileConfig `yaml:"reconcile"`
+
+	// SkipKnown skips file scans of files which the server already knows with the same size.
+	SkipKnown bool `yaml:"skip-known"`
+}
+
+type target struct {
+	server    Server
+	url       string
+	token     string
+	libraries *autoscan.LibraryCache
+	skipKnown bool
+	reconcile autoscan.ReconcileConfig
+
+	log       zerolog.Logger
+	rewrite   autoscan.Rewriter
+	unrewrite autoscan.Rewriter
+	api       apiClient
+}
+
+func New(server Server, c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", server.Name).
+		Str("url", c.URL).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	unrewriter, err := autoscan.NewReverseRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	api := newAPIClient(server, c.URL, c.Token, l)
+
+	t := &target{
+		server:    server,
+		url:       c.URL,
+		token:     c.Token,
+		skipKnown: c.SkipKnown,
+		reconcile: c.Reconcile,
+
+		log:       l,
+		rewrite:   rewriter,
+		unrewrite: unrewriter,
+		api:       api,
+	}
+
+	t.libraries, err = autoscan.NewLibraryCache(t.api.Libraries, c.LibraryRefresh, c.Libraries, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t target) Available() error {
+	return t.api.Available()
+}
+
+func (t target) Scan(scan autoscan.Scan) error {
+	return t.ScanBatch([]autoscan.Scan{scan})
+}
+
+// ScanBatch sends the scans in a single request.
+func (t target) ScanBatch(scans []autoscan.Scan) error {
+	updates := make([]scanRequest, 0, len(scans))
+	loggers := make([]zerolog.Logger, 0, len(scans))
+
+	// libraries sharing a folder only need to be scanned once
+	scanned := make(map[string]bool)
+
+	for _, scan := range scans {
+		// determine library for this scan
+		scanFolder := t.rewrite(scan.Folder)
+
+		libs, err := t.libraries.MatchScan(scanFolder)
+		if err != nil {
+			t.log.Warn().
+				Err(err).
+				Msg("No target libraries found")
+
+			continue
+		}
+
+		for _, lib := range libs {
+			// scan the file instead of the whole folder when it is known
+			scanPath := lib.Folder
+			if scan.File != "" {
+				scanPath = lib.Rewrite(t.rewrite(scan.File))
+			}
+
+			if scanned[scanPath] {
+				continue
+			}
+
+			scanned[scanPath] = true
+
+			l := t.log.With().
+				Str("path", scanPath).
+				Str("library", lib.Library.Name).
+				Str("event", string(scan.Event)).
+				Logger()
+
+			if t.skipKnown && t.known(l, lib, scan) {
+				l.Info().Msg("File already known, skipping scan")
+				continue
+			}
+
+			l.Trace().Msg("Sending scan request")
+
+			updates = append(updates, scanRequest{
+				Path:       scanPath,
+				UpdateType: t.server.UpdateType(scan.Event),
+			})
+
+			loggers = append(loggers, l)
+		}
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	// send scan request
+	if err := t.api.Scan(updates); err != nil {
+		return err
+	}
+
+	for _, l := range loggers {
+		l.Info().Msg("Scan moved to target")
+	}
+
+	return nil
+}
+
+// known reports whether the server already knows the file of the scan with the same size.
+// Scans without a file, deletions and failed lookups are never skipped.
+func (t target) known(l zerolog.Logger, lib autoscan.LibraryMatch, scan autoscan.Scan) bool {
+	if scan.File == "" || scan.Event == autoscan.EventDeleted {
+		return false
+	}
+
+	info, err := os.Stat(scan.File)
+	if err != nil {
+		return false
+	}
+
+	file := lib.Rewrite(t.rewrite(scan.File))
+	parts, err := t.api.Parts(autoscan.MediaTitle(lib.Library.Path, file))
+	if err != nil {
+		l.Warn().
+			Err(err).
+			Msg("Failed searching for file, scanning anyway")
+
+		return false
+	}
+
+	for _, p := range parts {
+		if p.File == file && p.Size == info.Size() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify returns the local media files of the scan which the server does not know.
+func (t target) Verify(scan autoscan.Scan) ([]string, error) {
+	path := scan.Folder
+	if scan.File != "" {
+		path = scan.File
+	}
+
+	files, err := autoscan.MediaFiles(path)
+	switch {
+	case os.IsNotExist(err):
+		// removed since the scan, nothing to verify
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// scans which did not match a library were never sent
+	libs, err := t.libraries.MatchScan(t.rewrite(scan.Folder))
+	if err != nil {
+		return nil, nil
+	}
+
+	missing := make([]string, 0)
+	for _, lib := range libs {
+		known := make(map[string]bool)
+		searched := make(map[string]bool)
+
+		for _, local := range files {
+			file := lib.Rewrite(t.rewrite(local))
+
+			title := autoscan.MediaTitle(lib.Library.Path, file)
+			if !searched[title] {
+				parts, err := t.api.Parts(title)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, p := range parts {
+					known[p.File] = true
+				}
+
+				searched[title] = true
+			}
+
+			if !known[file] {
+				missing = append(missing, local)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// Reconciliation returns the schedule of the reconciliation.
+func (t target) Reconciliation() autoscan.ReconcileConfig {
+	return t.reconcile
+}
+
+// LocalRoots returns the paths of the libraries on the local file system.
+func (t target) LocalRoots() []string {
+	return t.libraries.Roots(t.unrewrite)
+}
+
+// Libraries retrieves the libraries and refreshes the libraries used to match scans.
+func (t target) Libraries() ([]autoscan.Library, error) {
+	return t.libraries.Refresh()
+}
+
+// ScanLibraries returns the libraries the scan would be sent to.
+func (t target) ScanLibraries(scan autoscan.Scan) []autoscan.Library {
+	libs, err := t.libraries.MatchScan(t.rewrite(scan.Folder))
+	if err != nil {
+		return nil
+	}
+
+	libraries := make([]autoscan.Library, 0, len(libs))
+	for _, lib := range libs {
+		libraries = append(libraries, lib.Library)
+	}
+
+	return libraries
+}
+
+// RefreshLibrary scans all libraries of the server, which includes the given library.
+func (t target) RefreshLibrary(lib autoscan.Library) error {
+	l := t.log.With().
+		Str("library", lib.Name).
+		Logger()
+
+	l.Trace().Msg("Sending library refresh request")
+
+	if err := t.api.RefreshLibraries(); err != nil {
+		return err
+	}
+
+	l.Info().Msg("Library refresh moved to target")
+	return nil
+}
+
+// RefreshAll scans all libraries of the server.
+func (t target) RefreshAll() error {
+	t.log.Trace().Msg("Sending refresh request of all libraries")
+
+	if err := t.api.RefreshLibraries(); err != nil {
+		return err
+	}
+
+	t.log.Info().Msg("Refresh of all libraries moved to target")
+	return nil
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	e := autoscan.Explanation{
+		Target: t.server.Name,
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	}
+
+	if scan.File != "" {
+		e.File = t.rewrite(scan.File)
+	}
+
+	return t.libraries.Explain(e)
+}
@@ -0,0 +1,297 @@
+package mediabrowser
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+var testServer = Server{
+	Name:          "test",
+	InfoPath:      []string{"System", "Info"},
+	LibrariesPath: []string{"Library", "VirtualFolders"},
+	ItemsPath:     []string{"Items"},
+	Libraries: func(r io.Reader) ([]autoscan.Library, error) {
+		libraries := make([]autoscan.Library, 0)
+		err := json.NewDecoder(r).Decode(&libraries)
+		return libraries, err
+	},
+	UpdateType: func(event autoscan.Event) string {
+		return string(event)
+	},
+}
+
+// fakeServer serves the libraries and the files it knows,
+// and records the scan and refresh requests it receives.
+type fakeServer struct {
+	libraries []autoscan.Library
+	files     map[string]int64
+
+	mu        sync.Mutex
+	token     string
+	status    int
+	updates   []scanRequest
+	refreshes int
+}
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("X-Emby-Token") != s.token {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if s.status != 0 {
+		rw.WriteHeader(s.status)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/System/Info":
+	case "/Library/VirtualFolders":
+		json.NewEncoder(rw).Encode(s.libraries)
+	case "/Items":
+		resp := struct{ Items []item }{}
+		for file, size := range s.files {
+			i := item{Type: "Movie"}
+			i.MediaSources = append(i.MediaSources, struct {
+				Path string `json:"Path"`
+				Size int64  `json:"Size"`
+			}{file, size})
+
+			resp.Items = append(resp.Items, i)
+		}
+
+		json.NewEncoder(rw).Encode(resp)
+	case "/Library/Media/Updated":
+		payload := struct{ Updates []scanRequest }{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		s.updates = append(s.updates, payload.Updates...)
+	case "/Library/Refresh":
+		s.refreshes++
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer, c Config) autoscan.Target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	c.URL = srv.URL
+	c.Token = "token"
+	fs.token = c.Token
+
+	target, err := New(testServer, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func TestScanBatch(t *testing.T) {
+	dir := t.TempDir()
+	movies := filepath.Join(dir, "Movies")
+	movie := filepath.Join(movies, "Interstellar (2014)")
+	if err := os.MkdirAll(movie, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(movie, "Interstellar.mkv")
+	if err := os.WriteFile(file, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type Test struct {
+		Name      string
+		SkipKnown bool
+		Known     map[string]int64
+		Scans     []autoscan.Scan
+		Expected  []scanRequest
+	}
+
+	var testCases = []Test{
+		{
+			Name:  "Folders are scanned in a single request",
+			Scans: []autoscan.Scan{{Folder: movie, Event: autoscan.EventModified}, {Folder: movies, Event: autoscan.EventCreated}},
+			Expected: []scanRequest{
+				{Path: movie, UpdateType: "modified"},
+				{Path: movies, UpdateType: "created"},
+			},
+		},
+		{
+			Name:     "Files are scanned instead of their folder",
+			Scans:    []autoscan.Scan{{Folder: movie, File: file, Event: autoscan.EventCreated}},
+			Expected: []scanRequest{{Path: file, UpdateType: "created"}},
+		},
+		{
+			Name:     "Paths are scanned once",
+			Scans:    []autoscan.Scan{{Folder: movie}, {Folder: movie}},
+			Expected: []scanRequest{{Path: movie}},
+		},
+		{
+			Name:  "Scans outside of the libraries are not sent",
+			Scans: []autoscan.Scan{{Folder: filepath.Join(dir, "TV", "Westworld")}},
+		},
+		{
+			Name:      "Known files are skipped",
+			SkipKnown: true,
+			Known:     map[string]int64{file: 5},
+			Scans:     []autoscan.Scan{{Folder: movie, File: file, Event: autoscan.EventCreated}},
+		},
+		{
+			Name:      "Known files with another size are scanned",
+			SkipKnown: true,
+			Known:     map[string]int64{file: 4},
+			Scans:     []autoscan.Scan{{Folder: movie, File: file, Event: autoscan.EventCreated}},
+			Expected:  []scanRequest{{Path: file, UpdateType: "created"}},
+		},
+		{
+			Name:      "Deleted files are never skipped",
+			SkipKnown: true,
+			Known:     map[string]int64{file: 5},
+			Scans:     []autoscan.Scan{{Folder: movie, File: file, Event: autoscan.EventDeleted}},
+			Expected:  []scanRequest{{Path: file, UpdateType: "deleted"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{
+				libraries: []autoscan.Library{{ID: "1", Name: "Movies", Path: movies}},
+				files:     tc.Known,
+			}
+
+			target := newTestTarget(t, fs, Config{SkipKnown: tc.SkipKnown})
+
+			err := target.(autoscan.BatchScanner).ScanBatch(tc.Scans)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(fs.updates, tc.Expected) {
+				t.Errorf("Updates do not match: %v vs %v (expected)", fs.updates, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	movies := filepath.Join(dir, "Movies")
+	movie := filepath.Join(movies, "Interstellar (2014)")
+	if err := os.MkdirAll(movie, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{filepath.Join(movie, "Interstellar.mkv"), filepath.Join(movie, "Interstellar.Extras.mkv")}
+	for _, file := range files {
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := &fakeServer{
+		libraries: []autoscan.Library{{ID: "1", Name: "Movies", Path: movies}},
+		files:     map[string]int64{files[0]: 0},
+	}
+
+	target := newTestTarget(t, fs, Config{})
+
+	missing, err := target.(autoscan.Verifier).Verify(autoscan.Scan{Folder: movie})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(missing, files[1:]) {
+		t.Errorf("Missing files do not match: %v vs %v (expected)", missing, files[1:])
+	}
+
+	missing, err = target.(autoscan.Verifier).Verify(autoscan.Scan{Folder: filepath.Join(movies, "Removed")})
+	if err != nil || len(missing) != 0 {
+		t.Errorf("Expected removed folders to be verified, got %v: %v", missing, err)
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	fs := &fakeServer{
+		libraries: []autoscan.Library{
+			{ID: "1", Name: "Movies", Path: "/data/Movies"},
+			{ID: "2", Name: "TV", Path: "/data/TV/"},
+		},
+	}
+
+	target := newTestTarget(t, fs, Config{})
+
+	libraries, err := target.(autoscan.LibraryLister).Libraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []autoscan.Library{
+		{ID: "1", Name: "Movies", Path: "/data/Movies/"},
+		{ID: "2", Name: "TV", Path: "/data/TV/"},
+	}
+
+	if !reflect.DeepEqual(libraries, expected) {
+		t.Errorf("Libraries do not match: %v vs %v (expected)", libraries, expected)
+	}
+
+	if err := target.(autoscan.LibraryRefresher).RefreshLibrary(libraries[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := target.(autoscan.AllLibrariesRefresher).RefreshAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fs.refreshes != 2 {
+		t.Errorf("Expected two refreshes, got %d", fs.refreshes)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	type Test struct {
+		Name     string
+		Token    string // token expected by the server after the target was created
+		Status   int
+		Expected error
+	}
+
+	var testCases = []Test{
+		{"Available", "token", 0, nil},
+		{"Invalid token", "changed", 0, autoscan.ErrFatal},
+		{"Not found", "token", http.StatusNotFound, autoscan.ErrTargetUnavailable},
+		{"Unavailable", "token", http.StatusServiceUnavailable, autoscan.ErrTargetUnavailable},
+		{"Bad request", "token", http.StatusBadRequest, autoscan.ErrFatal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{}
+			target := newTestTarget(t, fs, Config{})
+
+			fs.mu.Lock()
+			fs.token = tc.Token
+			fs.status = tc.Status
+			fs.mu.Unlock()
+
+			err := target.Available()
+			if !errors.Is(err, tc.Expected) {
+				t.Errorf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package plex
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// defaultTrashItems is the maximum number of items in the trash
+// which are deleted when the threshold is not configured.
+const defaultTrashItems = 10
+
+// defaultActionWait is the maximum time to wait for a scan to finish
+// before running the actions, when the target does not configure a maximum wait.
+const defaultActionWait = 10 * time.Minute
+
+type EmptyTrashConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Anchors must all exist before the trash is emptied,
+	// the anchor files of the processor are used when not set.
+	Anchors []string `yaml:"anchors"`
+
+	// MaxItems is the maximum number of items the trash may hold to be emptied.
+	// A larger trash points to a remote mount which went offline.
+	MaxItems int `yaml:"max-items"`
+}
+
+// LibraryActions are the actions for the library with the given name or ID.
+type LibraryActions struct {
+	Library string `yaml:"library"`
+
+	// Analyze analyzes the media of the scanned items.
+	Analyze bool `yaml:"analyze"`
+
+	// Refresh refreshes the metadata of the scanned items.
+	Refresh bool `yaml:"refresh"`
+}
+
+func (t target) libraryActions(lib autoscan.Library) LibraryActions {
+	for _, a := range t.actions {
+		if a.Library == lib.Name || a.Library == lib.ID {
+			return a
+		}
+	}
+
+	return LibraryActions{}
+}
+
+// actionWorkers runs the post-scan actions with a single worker per library,
+// so a large import does not result in a goroutine polling Plex for every scanned folder.
+type actionWorkers struct {
+	mu     sync.Mutex
+	queues map[string]map[string]*actionJob
+}
+
+// An actionJob holds the actions for a scanned folder of a library.
+type actionJob struct {
+	l          zerolog.Logger
+	lib        autoscan.LibraryMatch
+	actions    LibraryActions
+	emptyTrash bool
+}
+
+// add queues the job and reports whether the library needs a new worker.
+// Jobs for a folder which is already queued are merged.
+func (w *actionWorkers) add(job *actionJob) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id := job.lib.Library.ID
+	queue, ok := w.queues[id]
+	if !ok {
+		queue = make(map[string]*actionJob)
+		w.queues[id] = queue
+	}
+
+	if queued, ok := queue[job.lib.Folder]; ok {
+		queued.emptyTrash = queued.emptyTrash || job.emptyTrash
+		return false
+	}
+
+	queue[job.lib.Folder] = job
+	return !ok
+}
+
+// take returns the queued jobs of the library.
+// Without queued jobs, the library is removed so the worker can stop.
+func (w *actionWorkers) take(id string) []*actionJob {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queue := w.queues[id]
+	if len(queue) == 0 {
+		delete(w.queues, id)
+		return nil
+	}
+
+	jobs := make([]*actionJob, 0, len(queue))
+	for _, job := range queue {
+		jobs = append(jobs, job)
+	}
+
+	w.queues[id] = make(map[string]*actionJob)
+	return jobs
+}
+
+// afterScan queues the actions of the library, which run in the background once Plex has finished the scan,
+// so waiting for Plex does not hold up the processor.
+// The scan itself has already succeeded, so failing actions are only logged.
+func (t target) afterScan(l zerolog.Logger, lib autoscan.LibraryMatch, scan autoscan.Scan) {
+	actions := t.libraryActions(lib.Library)
+	emptyTrash := t.emptyTrash.Enabled && scan.Event == autoscan.EventDeleted
+
+	if !actions.Analyze && !actions.Refresh && !emptyTrash {
+		return
+	}
+
+	job := &actionJob{
+		l:          l,
+		lib:        lib,
+		actions:    actions,
+		emptyTrash: emptyTrash,
+	}
+
+	if t.workers.add(job) {
+		go t.runActions(lib.Library)
+	}
+}
+
+// runActions waits for Plex to finish scanning the library and runs the actions of the queued jobs,
+// until no more jobs are queued for the library.
+func (t target) runActions(lib autoscan.Library) {
+	l := t.log.With().
+		Str("library", lib.Name).
+		Logger()
+
+	maxWait := t.maxWait
+	if maxWait <= 0 {
+		maxWait = defaultActionWait
+	}
+
+	for {
+		// give Plex the chance to pick up the scans
+		time.Sleep(idlePoll)
+
+		idleErr := t.waitIdle(l, lib.ID, maxWait)
+
+		jobs := t.workers.take(lib.ID)
+		if len(jobs) == 0 {
+			return
+		}
+
+		if idleErr != nil {
+			l.Warn().
+				Err(idleErr).
+				Int("folders", len(jobs)).
+				Msg("Failed waiting for the scan to finish, skipping actions")
+
+			continue
+		}
+
+		emptyTrash := false
+		for _, job := range jobs {
+			if job.actions.Analyze || job.actions.Refresh {
+				t.runItemActions(job.l, job.lib, job.actions)
+			}
+
+			emptyTrash = emptyTrash || job.emptyTrash
+		}
+
+		if emptyTrash {
+			t.emptyTrashIfSafe(l, lib.ID)
+		}
+	}
+}
+
+func (t target) runItemActions(l zerolog.Logger, lib autoscan.LibraryMatch, actions LibraryActions) {
+	// a scan of the library root would run the actions on every item
+	if strings.TrimSuffix(lib.Folder, "/") == strings.TrimSuffix(lib.Library.Path, "/") {
+		l.Debug().Msg("Scan of library root, skipping item actions")
+		return
+	}
+
+	// only the items with the title of the scanned folder are retrieved,
+	// the trailing slash keeps dots in the folder name from being read as an extension
+	title := autoscan.MediaTitle(lib.Library.Path, lib.Folder+"/")
+	items, err := t.api.Items(lib.Library.ID, title)
+	if err != nil {
+		l.Warn().
+			Err(err).
+			Msg("Failed retrieving items of library")
+
+		return
+	}
+
+	for _, i := range items {
+		if !i.contains(lib.Folder) {
+			continue
+		}
+
+		il := l.With().
+			Str("item", i.Title).
+			Logger()
+
+		if actions.Analyze {
+			if err := t.api.Analyze(i.RatingKey); err != nil {
+				il.Warn().Err(err).Msg("Failed analyzing item")
+			} else {
+				il.Info().Msg("Item analyzed")
+			}
+		}
+
+		if actions.Refresh {
+			if err := t.api.Refresh(i.RatingKey); err != nil {
+				il.Warn().Err(err).Msg("Failed refreshing item metadata")
+			} else {
+				il.Info().Msg("Item metadata refreshed")
+			}
+		}
+	}
+}
+
+// emptyTrashIfSafe only empties the trash when all anchors exist
+// and the trash holds no more than the maximum number of items.
+func (t target) emptyTrashIfSafe(l zerolog.Logger, sectionID string) {
+	for _, anchor := range t.emptyTrash.Anchors {
+		if _, err := os.Stat(anchor); err != nil {
+			l.Warn().
+				Str("anchor", anchor).
+				Msg("Anchor file is unavailable, not emptying trash")
+
+			return
+		}
+	}
+
+	size, err := t.api.TrashSize(sectionID)
+	if err != nil {
+		l.Warn().
+			Err(err).
+			Msg("Failed retrieving trash")
+
+		return
+	}
+
+	switch {
+	case size == 0:
+		return
+	case size > t.emptyTrash.MaxItems:
+		l.Warn().
+			Int("items", size).
+			Int("max_items", t.emptyTrash.MaxItems).
+			Msg("Too many items in trash, not emptying trash")
+
+		return
+	}
+
+	if err := t.api.EmptyTrash(sectionID); err != nil {
+		l.Warn().
+			Err(err).
+			Msg("Failed emptying trash")
+
+		return
+	}
+
+	l.Info().
+		Int("items", size).
+		Msg("Trash emptied")
+}
+
+// contains reports whether the item is stored within the folder,
+// or the folder is part of the item, such as a season of a show.
+func (i item) contains(folder string) bool {
+	for _, p := range i.Paths {
+		if autoscan.ContainsPath(folder, p) || autoscan.ContainsPath(p, folder) {
+			return true
+		}
+	}
+
+	return false
+}
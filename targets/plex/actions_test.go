@@ -0,0 +1,83 @@
+package plex
+
+import (
+	"testing"
+
+	"github.com/cloudbox/autoscan"
+)
+
+func TestActionWorkers(t *testing.T) {
+	w := &actionWorkers{queues: make(map[string]map[string]*actionJob)}
+
+	tv := autoscan.Library{ID: "1", Name: "TV"}
+	movies := autoscan.Library{ID: "2", Name: "Movies"}
+
+	job := func(lib autoscan.Library, folder string, emptyTrash bool) *actionJob {
+		return &actionJob{
+			lib:        autoscan.LibraryMatch{Library: lib, Folder: folder},
+			emptyTrash: emptyTrash,
+		}
+	}
+
+	if !w.add(job(tv, "/data/TV/Westworld", false)) {
+		t.Error("Expected a worker for the first job of a library")
+	}
+
+	if w.add(job(tv, "/data/TV/The Expanse", false)) {
+		t.Error("Expected a single worker per library")
+	}
+
+	if w.add(job(tv, "/data/TV/Westworld", true)) {
+		t.Error("Expected a single worker per library")
+	}
+
+	if !w.add(job(movies, "/data/Movies/Interstellar", false)) {
+		t.Error("Expected a worker for another library")
+	}
+
+	// jobs of the same folder are merged
+	jobs := w.take(tv.ID)
+	if len(jobs) != 2 {
+		t.Fatalf("Expected two jobs, got %d", len(jobs))
+	}
+
+	for _, j := range jobs {
+		if j.lib.Folder == "/data/TV/Westworld" && !j.emptyTrash {
+			t.Error("Expected the jobs of the same folder to be merged")
+		}
+	}
+
+	// the worker keeps running while jobs are added
+	if w.add(job(tv, "/data/TV/Westworld", false)) {
+		t.Error("Expected the running worker to pick up the job")
+	}
+
+	if jobs := w.take(tv.ID); len(jobs) != 1 {
+		t.Errorf("Expected a single job, got %d", len(jobs))
+	}
+
+	// the worker stops without jobs, after which a new worker is needed
+	if jobs := w.take(tv.ID); len(jobs) != 0 {
+		t.Errorf("Expected no jobs, got %d", len(jobs))
+	}
+
+	if !w.add(job(tv, "/data/TV/Westworld", false)) {
+		t.Error("Expected a new worker after the previous one stopped")
+	}
+}
+
+func TestItemContains(t *testing.T) {
+	i := item{Paths: []string{"/data/TV/Westworld/Season 1/s01e01.mkv"}}
+
+	for folder, expected := range map[string]bool{
+		"/data/TV/Westworld":                     true,
+		"/data/TV/Westworld/Season 1":            true,
+		"/data/TV/Westworld/Season 1/s01e01.mkv": true,
+		"/data/TV/Westworld/Season 2":            false,
+		"/data/TV/West":                          false,
+	} {
+		if got := i.contains(folder); got != expected {
+			t.Errorf("contains(%q) = %v, want %v", folder, got, expected)
+		}
+	}
+}
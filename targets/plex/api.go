@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
 
 	"github.com/rs/zerolog"
 
@@ -146,22 +145,304 @@ func (c apiClient) Libraries() ([]library, error) {
 	return libraries, nil
 }
 
-func (c apiClient) Scan(path string, libraryID int) error {
-	reqURL := autoscan.JoinURL(c.baseURL, "library", "sections", strconv.Itoa(libraryID), "refresh")
+type activity struct {
+	Type      string
+	SectionID string
+}
+
+func (c apiClient) Activities() ([]activity, error) {
+	reqURL := autoscan.JoinURL(c.baseURL, "activities")
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating activities request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("activities: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		MediaContainer struct {
+			Activities []struct {
+				Type    string                 `json:"type"`
+				Context map[string]interface{} `json:"Context"`
+			} `json:"Activity"`
+		} `json:"MediaContainer"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed decoding activities response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	activities := make([]activity, 0, len(resp.MediaContainer.Activities))
+	for _, a := range resp.MediaContainer.Activities {
+		// the section is sent as a string, but accept a number as well
+		sectionID := ""
+		if id, ok := a.Context["librarySectionID"]; ok && id != nil {
+			sectionID = fmt.Sprint(id)
+		}
+
+		activities = append(activities, activity{
+			Type:      a.Type,
+			SectionID: sectionID,
+		})
+	}
+
+	return activities, nil
+}
+
+// Scan scans the path within a library, or the whole library when the path is empty.
+func (c apiClient) Scan(path string, libraryID string) error {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "sections", libraryID, "refresh")
 	req, err := http.NewRequest("GET", reqURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed creating scan request: %v: %w", err, autoscan.ErrFatal)
 	}
 
+	if path != "" {
+		q := url.Values{}
+		q.Add("path", path)
+		req.URL.RawQuery = q.Encode()
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	res.Body.Close()
+	return nil
+}
+
+type item struct {
+	RatingKey string
+	Title     string
+	Paths     []string
+}
+
+// Items returns the top-level items of a library section with the given title,
+// such as movies, shows and artists, with the paths of their folders and files.
+func (c apiClient) Items(sectionID string, title string) ([]item, error) {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "sections", sectionID, "all")
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating items request: %v: %w", err, autoscan.ErrFatal)
+	}
+
 	q := url.Values{}
-	q.Add("path", path)
+	q.Add("title", title)
 	req.URL.RawQuery = q.Encode()
 
 	res, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("scan: %w", err)
+		return nil, fmt.Errorf("items: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		MediaContainer struct {
+			Metadata []struct {
+				RatingKey string `json:"ratingKey"`
+				Title     string `json:"title"`
+				Locations []struct {
+					Path string `json:"path"`
+				} `json:"Location"`
+				Media []struct {
+					Parts []struct {
+						File string `json:"file"`
+					} `json:"Part"`
+				} `json:"Media"`
+			} `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed decoding items response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	items := make([]item, 0, len(resp.MediaContainer.Metadata))
+	for _, m := range resp.MediaContainer.Metadata {
+		i := item{
+			RatingKey: m.RatingKey,
+			Title:     m.Title,
+		}
+
+		for _, l := range m.Locations {
+			i.Paths = append(i.Paths, l.Path)
+		}
+
+		for _, media := range m.Media {
+			for _, part := range media.Parts {
+				i.Paths = append(i.Paths, part.File)
+			}
+		}
+
+		items = append(items, i)
+	}
+
+	return items, nil
+}
+
+// TrashSize returns the number of items in the trash of a library section.
+func (c apiClient) TrashSize(sectionID string) (int, error) {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "sections", sectionID, "all")
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed creating trash request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	// only the total size is needed
+	q := url.Values{}
+	q.Add("trash", "1")
+	q.Add("X-Plex-Container-Start", "0")
+	q.Add("X-Plex-Container-Size", "0")
+	req.URL.RawQuery = q.Encode()
+
+	res, err := c.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("trash: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		MediaContainer struct {
+			TotalSize int `json:"totalSize"`
+		} `json:"MediaContainer"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return 0, fmt.Errorf("failed decoding trash response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	return resp.MediaContainer.TotalSize, nil
+}
+
+func (c apiClient) EmptyTrash(sectionID string) error {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "sections", sectionID, "emptyTrash")
+	return c.put(reqURL, "empty trash")
+}
+
+func (c apiClient) Analyze(ratingKey string) error {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "metadata", ratingKey, "analyze")
+	return c.put(reqURL, "analyze")
+}
+
+func (c apiClient) Refresh(ratingKey string) error {
+	reqURL := autoscan.JoinURL(c.baseURL, "library", "metadata", ratingKey, "refresh")
+	return c.put(reqURL, "refresh")
+}
+
+// put sends a PUT request without a body, which is how Plex triggers most actions.
+func (c apiClient) put(reqURL string, action string) error {
+	req, err := http.NewRequest("PUT", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating %s request: %v: %w", action, err, autoscan.ErrFatal)
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
 	}
 
 	res.Body.Close()
 	return nil
 }
+
+type part struct {
+	File string
+	Size int64
+}
+
+// Parts returns the files of the media in a library section with the given title,
+// including the episodes of shows and the tracks of artists.
+func (c apiClient) Parts(sectionID string, title string) ([]part, error) {
+	q := url.Values{}
+	q.Add("title", title)
+
+	metadata, err := c.metadata(autoscan.JoinURL(c.baseURL, "library", "sections", sectionID, "all"), q)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]part, 0)
+	for _, m := range metadata {
+		if len(m.Media) == 0 {
+			// shows and artists hold their files in their leaves
+			leaves, err := c.metadata(autoscan.JoinURL(c.baseURL, "library", "metadata", m.RatingKey, "allLeaves"), nil)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, leaf := range leaves {
+				parts = append(parts, leaf.parts()...)
+			}
+
+			continue
+		}
+
+		parts = append(parts, m.parts()...)
+	}
+
+	return parts, nil
+}
+
+type metadata struct {
+	RatingKey string `json:"ratingKey"`
+	Media     []struct {
+		Parts []struct {
+			File string `json:"file"`
+			Size int64  `json:"size"`
+		} `json:"Part"`
+	} `json:"Media"`
+}
+
+func (m metadata) parts() []part {
+	parts := make([]part, 0)
+	for _, media := range m.Media {
+		for _, p := range media.Parts {
+			parts = append(parts, part{
+				File: p.File,
+				Size: p.Size,
+			})
+		}
+	}
+
+	return parts
+}
+
+func (c apiClient) metadata(reqURL string, q url.Values) ([]metadata, error) {
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating search request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		MediaContainer struct {
+			Metadata []metadata `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+
+	resp := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed decoding search response: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	return resp.MediaContainer.Metadata, nil
+}
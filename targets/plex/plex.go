@@ -1,9 +1,12 @@
 package plex
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -15,16 +18,60 @@ type Config struct {
 	Token     string             `yaml:"token"`
 	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
 	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// LibraryRefresh is the interval at which the libraries are retrieved again.
+	LibraryRefresh time.Duration `yaml:"library-refresh"`
+
+	// Libraries determines how scans are matched to libraries.
+	Libraries autoscan.LibraryConfig `yaml:"libraries"`
+
+	// MaxWait is the maximum time to wait for the scanner of a library to go idle
+	// before sending the next scan to it. Scans are sent right away when not set.
+	MaxWait time.Duration `yaml:"max-wait"`
+
+	// Reconcile compares the media files on disk with the libraries on a schedule.
+	Reconcile autoscan.ReconcileConfig `yaml:"reconcile"`
+
+	// SkipKnown skips file scans of files which Plex already knows with the same size.
+	SkipKnown bool `yaml:"skip-known"`
+
+	// EmptyTrash empties the trash of a library after a deletion has been scanned.
+	EmptyTrash EmptyTrashConfig `yaml:"empty-trash"`
+
+	// Actions are run on the scanned items of a library after the scan has finished.
+	Actions []LibraryActions `yaml:"actions"`
+
+	// Scanner sends scans to the Plex Media Scanner binary instead of the API.
+	Scanner ScannerConfig `yaml:"scanner"`
+}
+
+// idlePoll is the interval at which the activities are checked while waiting.
+const idlePoll = 5 * time.Second
+
+// scannerActivities are the activities which keep a library busy.
+var scannerActivities = map[string]bool{
+	"library.update.section": true,
+	"library.refresh.items":  true,
 }
 
 type target struct {
 	url       string
 	token     string
-	libraries []library
-
-	log     zerolog.Logger
-	rewrite autoscan.Rewriter
-	api     *apiClient
+	libraries *autoscan.LibraryCache
+	maxWait   time.Duration
+	skipKnown bool
+	reconcile autoscan.ReconcileConfig
+
+	emptyTrash EmptyTrashConfig
+	actions    []LibraryActions
+	workers    *actionWorkers
+	scanner    *scanner
+
+	log       zerolog.Logger
+	rewrite   autoscan.Rewriter
+	unrewrite autoscan.Rewriter
+	api       *apiClient
 }
 
 func New(c Config) (autoscan.Target, error) {
@@ -37,48 +84,80 @@ func New(c Config) (autoscan.Target, error) {
 		return nil, err
 	}
 
+	unrewriter, err := autoscan.NewReverseRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
 	api := newAPIClient(c.URL, c.Token, l)
 
+	// the version only matters to the API, the scanner is used when configured
 	version, err := api.Version()
-	if err != nil {
+	switch {
+	case err != nil && c.Scanner.Binary == "":
 		return nil, err
+	case err != nil:
+		l.Warn().
+			Err(err).
+			Msg("Failed retrieving Plex version")
+	case !isSupportedVersion(version):
+		return nil, fmt.Errorf("plex running unsupported version %s: %w", version, autoscan.ErrFatal)
+	default:
+		l.Debug().Msgf("Plex version: %s", version)
 	}
 
-	l.Debug().Msgf("Plex version: %s", version)
-	if !isSupportedVersion(version) {
-		return nil, fmt.Errorf("plex running unsupported version %s: %w", version, autoscan.ErrFatal)
+	t := &target{
+		url:       c.URL,
+		token:     c.Token,
+		maxWait:   c.MaxWait,
+		skipKnown: c.SkipKnown,
+		reconcile: c.Reconcile,
+
+		emptyTrash: c.EmptyTrash,
+		actions:    c.Actions,
+		workers:    &actionWorkers{queues: make(map[string]map[string]*actionJob)},
+
+		log:       l,
+		rewrite:   rewriter,
+		unrewrite: unrewriter,
+		api:       api,
 	}
 
-	libraries, err := api.Libraries()
-	if err != nil {
-		return nil, err
+	if c.Scanner.Binary != "" {
+		t.scanner, err = newScanner(c.Scanner)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	l.Debug().
-		Interface("libraries", libraries).
-		Msg("Retrieved libraries")
+	if t.emptyTrash.Enabled && t.emptyTrash.MaxItems <= 0 {
+		t.emptyTrash.MaxItems = defaultTrashItems
+	}
 
-	return &target{
-		url:       c.URL,
-		token:     c.Token,
-		libraries: libraries,
+	t.libraries, err = autoscan.NewLibraryCache(t.fetchLibraries, c.LibraryRefresh, c.Libraries, l)
+	if err != nil {
+		return nil, err
+	}
 
-		log:     l,
-		rewrite: rewriter,
-		api:     api,
-	}, nil
+	return t, nil
 }
 
 func (t target) Available() error {
+	if t.scanner != nil {
+		return t.scanner.Available()
+	}
+
 	_, err := t.api.Version()
 	return err
 }
 
 func (t target) Scan(scan autoscan.Scan) error {
-	// determine library for this scan
+	// determine library for this scan.
+	// Plex only scans folders, so file scans are sent as the folder of the file,
+	// which is the narrowest path Plex accepts.
 	scanFolder := t.rewrite(scan.Folder)
 
-	libs, err := t.getScanLibrary(scanFolder)
+	libs, err := t.libraries.MatchScan(scanFolder)
 	if err != nil {
 		t.log.Warn().
 			Err(err).
@@ -90,38 +169,262 @@ func (t target) Scan(scan autoscan.Scan) error {
 	// send scan request
 	for _, lib := range libs {
 		l := t.log.With().
-			Str("path", scanFolder).
-			Str("library", lib.Name).
+			Str("path", lib.Folder).
+			Str("library", lib.Library.Name).
 			Logger()
 
+		if t.skipKnown && t.known(l, lib, scan) {
+			l.Info().
+				Str("file", scan.File).
+				Msg("File already known, skipping scan")
+
+			continue
+		}
+
+		if err := t.waitIdle(l, lib.Library.ID, t.maxWait); err != nil {
+			return err
+		}
+
 		l.Trace().Msg("Sending scan request")
 
-		if err := t.api.Scan(scanFolder, lib.ID); err != nil {
+		err := t.scan(l, lib.Folder, lib.Library.ID)
+		switch {
+		case errors.Is(err, errScanDropped):
+			continue
+		case err != nil:
 			return err
 		}
 
 		l.Info().Msg("Scan moved to target")
+
+		t.afterScan(l, lib, scan)
 	}
 
 	return nil
 }
 
-func (t target) getScanLibrary(folder string) ([]library, error) {
-	libraries := make([]library, 0)
+// scan scans the path within the library section through the scanner binary when configured,
+// or through the API otherwise.
+func (t target) scan(l zerolog.Logger, path string, sectionID string) error {
+	if t.scanner != nil {
+		return t.scanner.Scan(l, path, sectionID)
+	}
+
+	return t.api.Scan(path, sectionID)
+}
+
+// waitIdle waits while the scanner is busy with the library,
+// for at most the maximum wait.
+func (t target) waitIdle(l zerolog.Logger, sectionID string, maxWait time.Duration) error {
+	if maxWait <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(maxWait)
+	for {
+		busy, err := t.sectionBusy(sectionID)
+		if err != nil {
+			return err
+		}
+
+		if !busy {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			l.Warn().
+				Dur("max_wait", maxWait).
+				Msg("Library still busy, no longer waiting")
+
+			return nil
+		}
+
+		l.Trace().Msg("Library busy, waiting for the scanner to go idle")
+
+		wait := idlePoll
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+func (t target) sectionBusy(sectionID string) (bool, error) {
+	activities, err := t.api.Activities()
+	if err != nil {
+		return false, err
+	}
+
+	for _, a := range activities {
+		if scannerActivities[a.Type] && a.SectionID == sectionID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// known reports whether Plex already knows the file of the scan with the same size.
+// Scans without a file, deletions and failed lookups are never skipped.
+func (t target) known(l zerolog.Logger, lib autoscan.LibraryMatch, scan autoscan.Scan) bool {
+	if scan.File == "" || scan.Event == autoscan.EventDeleted {
+		return false
+	}
 
-	for _, l := range t.libraries {
-		if strings.HasPrefix(folder, l.Path) {
-			libraries = append(libraries, l)
+	info, err := os.Stat(scan.File)
+	if err != nil {
+		return false
+	}
+
+	file := lib.Rewrite(t.rewrite(scan.File))
+	parts, err := t.api.Parts(lib.Library.ID, autoscan.MediaTitle(lib.Library.Path, file))
+	if err != nil {
+		l.Warn().
+			Err(err).
+			Msg("Failed searching for file, scanning anyway")
+
+		return false
+	}
+
+	for _, p := range parts {
+		if p.File == file && p.Size == info.Size() {
+			return true
 		}
 	}
 
-	if len(libraries) == 0 {
-		return nil, fmt.Errorf("%v: failed determining libraries", folder)
+	return false
+}
+
+// Verify returns the local media files of the scan which Plex does not know.
+func (t target) Verify(scan autoscan.Scan) ([]string, error) {
+	path := scan.Folder
+	if scan.File != "" {
+		path = scan.File
+	}
+
+	files, err := autoscan.MediaFiles(path)
+	switch {
+	case os.IsNotExist(err):
+		// removed since the scan, nothing to verify
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// scans which did not match a library were never sent
+	libs, err := t.libraries.MatchScan(t.rewrite(scan.Folder))
+	if err != nil {
+		return nil, nil
+	}
+
+	missing := make([]string, 0)
+	for _, lib := range libs {
+		known := make(map[string]bool)
+		searched := make(map[string]bool)
+
+		for _, local := range files {
+			file := lib.Rewrite(t.rewrite(local))
+
+			title := autoscan.MediaTitle(lib.Library.Path, file)
+			if !searched[title] {
+				parts, err := t.api.Parts(lib.Library.ID, title)
+				if err != nil {
+					return nil, err
+				}
+
+				for _, p := range parts {
+					known[p.File] = true
+				}
+
+				searched[title] = true
+			}
+
+			if !known[file] {
+				missing = append(missing, local)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// Reconciliation returns the schedule of the reconciliation.
+func (t target) Reconciliation() autoscan.ReconcileConfig {
+	return t.reconcile
+}
+
+// LocalRoots returns the paths of the libraries on the local file system.
+func (t target) LocalRoots() []string {
+	return t.libraries.Roots(t.unrewrite)
+}
+
+// Libraries retrieves the libraries and refreshes the libraries used to match scans.
+func (t target) Libraries() ([]autoscan.Library, error) {
+	return t.libraries.Refresh()
+}
+
+// ScanLibraries returns the libraries the scan would be sent to.
+func (t target) ScanLibraries(scan autoscan.Scan) []autoscan.Library {
+	libs, err := t.libraries.MatchScan(t.rewrite(scan.Folder))
+	if err != nil {
+		return nil
+	}
+
+	libraries := make([]autoscan.Library, 0, len(libs))
+	for _, lib := range libs {
+		libraries = append(libraries, lib.Library)
+	}
+
+	return libraries
+}
+
+// RefreshLibrary scans the whole library.
+func (t target) RefreshLibrary(lib autoscan.Library) error {
+	l := t.log.With().
+		Str("library", lib.Name).
+		Logger()
+
+	if err := t.waitIdle(l, lib.ID, t.maxWait); err != nil {
+		return err
+	}
+
+	l.Trace().Msg("Sending library refresh request")
+
+	if err := t.scan(l, "", lib.ID); err != nil {
+		return err
+	}
+
+	l.Info().Msg("Library refresh moved to target")
+	return nil
+}
+
+func (t target) fetchLibraries() ([]autoscan.Library, error) {
+	libs, err := t.api.Libraries()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]autoscan.Library, 0, len(libs))
+	for _, lib := range libs {
+		libraries = append(libraries, autoscan.Library{
+			ID:   strconv.Itoa(lib.ID),
+			Name: lib.Name,
+			Path: lib.Path,
+		})
 	}
 
 	return libraries, nil
 }
 
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	return t.libraries.Explain(autoscan.Explanation{
+		Target: "plex",
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	})
+}
+
 func isSupportedVersion(version string) bool {
 	parts := strings.Split(version, ".")
 	if len(parts) < 2 {
@@ -0,0 +1,151 @@
+package plex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// ScannerConfig runs the Plex Media Scanner binary of a Plex server on the same host
+// instead of sending scans through the API.
+type ScannerConfig struct {
+	// Binary is the path to the Plex Media Scanner executable.
+	Binary string `yaml:"binary"`
+
+	// LibraryPath is the LD_LIBRARY_PATH of the scanner,
+	// the lib directory next to the binary when not set.
+	LibraryPath string `yaml:"ld-library-path"`
+
+	// SupportDir is the PLEX_MEDIA_SERVER_APPLICATION_SUPPORT_DIR of the scanner.
+	SupportDir string `yaml:"support-dir"`
+
+	// User runs the scanner as the given user through sudo.
+	User string `yaml:"user"`
+
+	// Timeout stops a scanner which runs longer, 30 minutes when not set.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// RetryExitCodes are the exit codes after which the scan is retried later,
+	// such as while Plex has locked its database. Scans are dropped after other exit codes.
+	RetryExitCodes []int `yaml:"retry-exit-codes"`
+}
+
+// defaultScannerTimeout is the maximum run time of the scanner when not configured.
+const defaultScannerTimeout = 30 * time.Minute
+
+// errScanDropped is returned by the scanner when the scan failed
+// in a way which retrying does not fix, the failure has been logged.
+var errScanDropped = errors.New("scan dropped")
+
+type scanner struct {
+	binary  string
+	user    string
+	env     []string
+	timeout time.Duration
+	retry   map[int]bool
+}
+
+func newScanner(c ScannerConfig) (*scanner, error) {
+	if c.SupportDir == "" {
+		return nil, fmt.Errorf("plex scanner support-dir is missing: %w", autoscan.ErrFatal)
+	}
+
+	if c.LibraryPath == "" {
+		c.LibraryPath = filepath.Join(filepath.Dir(c.Binary), "lib")
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = defaultScannerTimeout
+	}
+
+	retry := make(map[int]bool, len(c.RetryExitCodes))
+	for _, code := range c.RetryExitCodes {
+		retry[code] = true
+	}
+
+	return &scanner{
+		binary: c.Binary,
+		user:   c.User,
+		env: []string{
+			"LD_LIBRARY_PATH=" + c.LibraryPath,
+			"PLEX_MEDIA_SERVER_APPLICATION_SUPPORT_DIR=" + c.SupportDir,
+		},
+		timeout: c.Timeout,
+		retry:   retry,
+	}, nil
+}
+
+func (s scanner) Available() error {
+	if _, err := os.Stat(s.binary); err != nil {
+		return fmt.Errorf("plex scanner: %v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	return nil
+}
+
+// Scan runs the scanner for the path within the library section,
+// or for the whole section when the path is empty.
+// Scans which time out or exit with an exit code which is not retried
+// are logged and return errScanDropped.
+func (s scanner) Scan(l zerolog.Logger, path string, sectionID string) error {
+	args := []string{"--scan", "--refresh", "--section", sectionID}
+	if path != "" {
+		args = append(args, "--directory", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if s.user != "" {
+		// sudo resets the environment, so it is passed through env instead
+		sudo := append([]string{"-u", s.user, "env"}, s.env...)
+		sudo = append(sudo, s.binary)
+		cmd = exec.CommandContext(ctx, "sudo", append(sudo, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, s.binary, args...)
+		cmd.Env = append(os.Environ(), s.env...)
+	}
+
+	output, err := cmd.CombinedOutput()
+
+	lines := bufio.NewScanner(bytes.NewReader(output))
+	for lines.Scan() {
+		l.Debug().
+			Str("stream", "scanner").
+			Msg(lines.Text())
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		l.Error().
+			Dur("timeout", s.timeout).
+			Msg("Plex scanner timed out, dropping scan")
+
+		return errScanDropped
+	case errors.As(err, &exitErr) && s.retry[exitErr.ExitCode()]:
+		return fmt.Errorf("plex scanner exited with %d: %w", exitErr.ExitCode(), autoscan.ErrTargetUnavailable)
+	case errors.As(err, &exitErr):
+		// a failing scan, such as of an unknown section, should not stop the processor
+		l.Error().
+			Int("exit_code", exitErr.ExitCode()).
+			Msg("Plex scanner failed, dropping scan")
+
+		return errScanDropped
+	case err != nil:
+		return fmt.Errorf("plex scanner: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
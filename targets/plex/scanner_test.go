@@ -0,0 +1,153 @@
+package plex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// fakeScanner writes its arguments and environment next to itself,
+// and exits with the exit code in SCANNER_EXIT or sleeps when it is "sleep".
+const fakeScanner = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$@" > "$dir/args"
+echo "$PLEX_MEDIA_SERVER_APPLICATION_SUPPORT_DIR" > "$dir/support"
+echo "scanning"
+if [ "$SCANNER_EXIT" = "sleep" ]; then
+	exec sleep 10
+fi
+exit "$SCANNER_EXIT"
+`
+
+func TestScanner(t *testing.T) {
+	type Test struct {
+		Name     string
+		Exit     string
+		Path     string
+		Binary   string
+		Expected error
+		Args     string
+	}
+
+	var testCases = []Test{
+		{
+			Name:     "Scans the directory within the section",
+			Exit:     "0",
+			Path:     "/data/TV/Westworld",
+			Expected: nil,
+			Args:     "--scan --refresh --section 2 --directory /data/TV/Westworld",
+		},
+		{
+			Name:     "Scans the whole section without a path",
+			Exit:     "0",
+			Expected: nil,
+			Args:     "--scan --refresh --section 2",
+		},
+		{
+			Name:     "Retries the configured exit codes",
+			Exit:     "3",
+			Path:     "/data/TV/Westworld",
+			Expected: autoscan.ErrTargetUnavailable,
+		},
+		{
+			Name:     "Drops the scan after other exit codes",
+			Exit:     "1",
+			Path:     "/data/TV/Westworld",
+			Expected: errScanDropped,
+		},
+		{
+			Name:     "Drops the scan after a timeout",
+			Exit:     "sleep",
+			Path:     "/data/TV/Westworld",
+			Expected: errScanDropped,
+		},
+		{
+			Name:     "Stops on a missing binary",
+			Binary:   "missing",
+			Path:     "/data/TV/Westworld",
+			Expected: autoscan.ErrFatal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			binary := filepath.Join(dir, "Plex Media Scanner")
+			if err := os.WriteFile(binary, []byte(fakeScanner), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.Binary != "" {
+				binary = filepath.Join(dir, tc.Binary)
+			}
+
+			t.Setenv("SCANNER_EXIT", tc.Exit)
+
+			s, err := newScanner(ScannerConfig{
+				Binary:         binary,
+				SupportDir:     "/var/lib/plexmediaserver",
+				Timeout:        500 * time.Millisecond,
+				RetryExitCodes: []int{3},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Scan(zerolog.Nop(), tc.Path, "2")
+			if !errors.Is(err, tc.Expected) {
+				t.Fatalf("Errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+
+			if tc.Args == "" {
+				return
+			}
+
+			args, err := os.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(string(args)); got != tc.Args {
+				t.Errorf("Arguments do not match: %q vs %q (expected)", got, tc.Args)
+			}
+
+			support, err := os.ReadFile(filepath.Join(dir, "support"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(string(support)); got != "/var/lib/plexmediaserver" {
+				t.Errorf("Unexpected support dir: %q", got)
+			}
+		})
+	}
+}
+
+func TestScannerConfig(t *testing.T) {
+	if _, err := newScanner(ScannerConfig{Binary: "/usr/lib/plexmediaserver/Plex Media Scanner"}); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected a missing support dir to be fatal, got %v", err)
+	}
+
+	s, err := newScanner(ScannerConfig{
+		Binary:     "/usr/lib/plexmediaserver/Plex Media Scanner",
+		SupportDir: "/var/lib/plexmediaserver",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.timeout != defaultScannerTimeout {
+		t.Errorf("Expected the default timeout, got %v", s.timeout)
+	}
+
+	if s.env[0] != "LD_LIBRARY_PATH=/usr/lib/plexmediaserver/lib" {
+		t.Errorf("Expected the lib directory next to the binary, got %v", s.env[0])
+	}
+}
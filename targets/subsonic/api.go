@@ -0,0 +1,176 @@
+package subsonic
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// apiVersion is the version of the Subsonic API which introduced token authentication.
+const apiVersion = "1.13.0"
+
+type apiClient struct {
+	client  *http.Client
+	log     zerolog.Logger
+	baseURL string
+	user    string
+	pass    string
+}
+
+func newAPIClient(baseURL string, user string, pass string, log zerolog.Logger) apiClient {
+	return apiClient{
+		client:  &http.Client{},
+		log:     log,
+		baseURL: baseURL,
+		user:    user,
+		pass:    pass,
+	}
+}
+
+func (c apiClient) do(req *http.Request) (*http.Response, error) {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	c.log.Trace().
+		Stringer("request_url", res.Request.URL).
+		Int("response_status", res.StatusCode).
+		Msg("Request failed")
+
+	// statusCode not in the 2xx range, close response
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 401:
+		return nil, fmt.Errorf("invalid subsonic credentials: %s: %w", res.Status, autoscan.ErrFatal)
+	case 404, 500, 502, 503, 504:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
+	default:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrFatal)
+	}
+}
+
+// call sends a request to the endpoint with token authentication
+// and decodes the subsonic-response into v.
+func (c apiClient) call(endpoint string, params url.Values, v interface{}) error {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed generating salt: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	s := hex.EncodeToString(salt)
+	token := md5.Sum([]byte(c.pass + s))
+
+	q := url.Values{}
+	for k, vs := range params {
+		q[k] = vs
+	}
+
+	q.Set("u", c.user)
+	q.Set("t", hex.EncodeToString(token[:]))
+	q.Set("s", s)
+	q.Set("v", apiVersion)
+	q.Set("c", "autoscan")
+	q.Set("f", "json")
+
+	req, err := http.NewRequest("GET", autoscan.JoinURL(c.baseURL, "rest", endpoint), nil)
+	if err != nil {
+		return fmt.Errorf("failed creating %s request: %v: %w", endpoint, err, autoscan.ErrFatal)
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+
+	defer res.Body.Close()
+
+	type Response struct {
+		Subsonic json.RawMessage `json:"subsonic-response"`
+	}
+
+	type Status struct {
+		Status string `json:"status"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	resp := new(Response)
+	status := new(Status)
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed decoding %s response: %v: %w", endpoint, err, autoscan.ErrFatal)
+	}
+
+	if err := json.Unmarshal(resp.Subsonic, status); err != nil {
+		return fmt.Errorf("failed decoding %s response: %v: %w", endpoint, err, autoscan.ErrFatal)
+	}
+
+	switch {
+	case status.Status == "ok":
+	case status.Error == nil:
+		return fmt.Errorf("%s: status %q: %w", endpoint, status.Status, autoscan.ErrFatal)
+	case status.Error.Code == 0:
+		// a generic error, all other codes point to the configuration
+		return fmt.Errorf("%s: %s: %w", endpoint, status.Error.Message, autoscan.ErrTargetUnavailable)
+	default:
+		return fmt.Errorf("%s: %s (%d): %w", endpoint, status.Error.Message, status.Error.Code, autoscan.ErrFatal)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp.Subsonic, v); err != nil {
+		return fmt.Errorf("failed decoding %s response: %v: %w", endpoint, err, autoscan.ErrFatal)
+	}
+
+	return nil
+}
+
+func (c apiClient) Available() error {
+	return c.call("ping", nil, nil)
+}
+
+type scanStatus struct {
+	Scanning bool  `json:"scanning"`
+	Count    int64 `json:"count"`
+}
+
+func (c apiClient) ScanStatus() (*scanStatus, error) {
+	type Response struct {
+		ScanStatus scanStatus `json:"scanStatus"`
+	}
+
+	resp := new(Response)
+	if err := c.call("getScanStatus", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.ScanStatus, nil
+}
+
+func (c apiClient) StartScan(fullScan bool) error {
+	params := url.Values{}
+	if fullScan {
+		params.Set("fullScan", "true")
+	}
+
+	return c.call("startScan", params, nil)
+}
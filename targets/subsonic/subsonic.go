@@ -0,0 +1,233 @@
+package subsonic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type Config struct {
+	URL       string             `yaml:"url"`
+	User      string             `yaml:"username"`
+	Pass      string             `yaml:"password"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+
+	// Paths are the music folders of the server, after rewriting.
+	// Scans outside of these folders are ignored, all scans are accepted when not set.
+	Paths []string `yaml:"paths"`
+
+	// FullScan rescans all files instead of only the changed ones.
+	FullScan bool `yaml:"full-scan"`
+}
+
+// idlePoll is the interval at which the scan status is checked
+// while a rescan is pending.
+const idlePoll = 5 * time.Second
+
+// maxStatusFailures is the number of consecutive failures to retrieve the scan status
+// after which a pending rescan is given up.
+const maxStatusFailures = 12
+
+type target struct {
+	url      string
+	paths    []string
+	fullScan bool
+	rescan   *rescan
+	poll     time.Duration
+
+	log     zerolog.Logger
+	rewrite autoscan.Rewriter
+	api     apiClient
+}
+
+func New(c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", "subsonic").
+		Str("url", c.URL).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	return &target{
+		url:      c.URL,
+		paths:    c.Paths,
+		fullScan: c.FullScan,
+		rescan:   new(rescan),
+		poll:     idlePoll,
+
+		log:     l,
+		rewrite: rewriter,
+		api:     newAPIClient(c.URL, c.User, c.Pass, l),
+	}, nil
+}
+
+func (t target) Available() error {
+	return t.api.Available()
+}
+
+func (t target) Scan(scan autoscan.Scan) error {
+	return t.ScanBatch([]autoscan.Scan{scan})
+}
+
+// ScanBatch starts a single scan for all music folders of the batch,
+// as the Subsonic API always scans the whole library.
+// When the server is already scanning, a single rescan follows the scan in progress.
+func (t target) ScanBatch(scans []autoscan.Scan) error {
+	folders := make([]string, 0, len(scans))
+	for _, scan := range scans {
+		folder := t.rewrite(scan.Folder)
+		if !t.musicFolder(folder) {
+			t.log.Debug().
+				Str("path", folder).
+				Msg("Not a music folder, ignoring scan")
+
+			continue
+		}
+
+		folders = append(folders, folder)
+	}
+
+	if len(folders) == 0 {
+		return nil
+	}
+
+	l := t.log.With().
+		Strs("paths", folders).
+		Logger()
+
+	status, err := t.api.ScanStatus()
+	if err != nil {
+		return err
+	}
+
+	// the scan in progress may have missed the folders, so another scan follows it
+	if status.Scanning {
+		t.queueRescan(l)
+		return nil
+	}
+
+	l.Trace().Msg("Sending scan request")
+
+	if err := t.api.StartScan(t.fullScan); err != nil {
+		return err
+	}
+
+	l.Info().Msg("Scan moved to target")
+	return nil
+}
+
+// A rescan tracks whether a scan is pending until the scan in progress has finished.
+type rescan struct {
+	mu      sync.Mutex
+	pending bool
+}
+
+// queueRescan starts a scan once the scan in progress has finished,
+// without blocking the processor. Folders arriving meanwhile are covered by the same scan.
+func (t target) queueRescan(l zerolog.Logger) {
+	t.rescan.mu.Lock()
+	defer t.rescan.mu.Unlock()
+
+	if t.rescan.pending {
+		l.Debug().Msg("Scan in progress, rescan already pending")
+		return
+	}
+
+	t.rescan.pending = true
+	l.Debug().Msg("Scan in progress, rescan pending")
+
+	go t.startRescan()
+}
+
+func (t target) startRescan() {
+	err := t.waitIdle()
+
+	// folders arriving from now on need another scan
+	t.rescan.mu.Lock()
+	t.rescan.pending = false
+	t.rescan.mu.Unlock()
+
+	if err != nil {
+		t.log.Error().
+			Err(err).
+			Msg("Failed retrieving scan status, giving up pending rescan")
+
+		return
+	}
+
+	t.log.Trace().Msg("Sending pending scan request")
+
+	if err := t.api.StartScan(t.fullScan); err != nil {
+		t.log.Error().
+			Err(err).
+			Msg("Failed starting pending rescan")
+
+		return
+	}
+
+	t.log.Info().Msg("Pending rescan moved to target")
+}
+
+// waitIdle waits until the server has finished scanning.
+// It returns the last error after failing to retrieve the scan status maxStatusFailures times in a row.
+func (t target) waitIdle() error {
+	failures := 0
+	for {
+		time.Sleep(t.poll)
+
+		status, err := t.api.ScanStatus()
+		switch {
+		case err != nil:
+			failures++
+			if failures >= maxStatusFailures {
+				return err
+			}
+
+			t.log.Warn().
+				Err(err).
+				Msg("Failed retrieving scan status of pending rescan")
+		case !status.Scanning:
+			return nil
+		default:
+			failures = 0
+		}
+	}
+}
+
+// musicFolder reports whether the folder is within one of the music folders.
+func (t target) musicFolder(folder string) bool {
+	if len(t.paths) == 0 {
+		return true
+	}
+
+	for _, path := range t.paths {
+		if autoscan.ContainsPath(path, folder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	e := autoscan.Explanation{
+		Target: "subsonic",
+		URL:    t.url,
+		Folder: t.rewrite(scan.Folder),
+	}
+
+	if !t.musicFolder(e.Folder) {
+		e.Error = fmt.Sprintf("%v: not within the music folders", e.Folder)
+	}
+
+	return e
+}
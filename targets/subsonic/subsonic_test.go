@@ -0,0 +1,224 @@
+package subsonic
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudbox/autoscan"
+)
+
+// fakeServer implements the scan endpoints of the Subsonic API
+// and records the salts and scans it receives.
+type fakeServer struct {
+	user string
+	pass string
+
+	mu         sync.Mutex
+	scanning   bool
+	statusCode int // status code of getScanStatus
+	salts      map[string]bool
+	scans      int
+}
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	respond := func(v map[string]interface{}) {
+		json.NewEncoder(rw).Encode(map[string]interface{}{"subsonic-response": v})
+	}
+
+	q := r.URL.Query()
+	token := md5.Sum([]byte(s.pass + q.Get("s")))
+	if q.Get("u") != s.user || q.Get("t") != hex.EncodeToString(token[:]) || q.Get("v") != apiVersion || q.Get("f") != "json" {
+		respond(map[string]interface{}{
+			"status": "failed",
+			"error":  map[string]interface{}{"code": 40, "message": "Wrong username or password"},
+		})
+		return
+	}
+
+	s.salts[q.Get("s")] = true
+
+	switch r.URL.Path {
+	case "/rest/ping":
+		respond(map[string]interface{}{"status": "ok"})
+	case "/rest/getScanStatus":
+		if s.statusCode != 0 {
+			rw.WriteHeader(s.statusCode)
+			return
+		}
+
+		respond(map[string]interface{}{
+			"status":     "ok",
+			"scanStatus": map[string]interface{}{"scanning": s.scanning},
+		})
+	case "/rest/startScan":
+		s.scans++
+		s.scanning = true
+		respond(map[string]interface{}{"status": "ok"})
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *fakeServer) set(f func(s *fakeServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f(s)
+}
+
+func (s *fakeServer) scanCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.scans
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer, c Config) *target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	fs.user, fs.pass = "admin", "secret"
+	fs.salts = make(map[string]bool)
+
+	c.URL = srv.URL
+	if c.User == "" {
+		c.User, c.Pass = fs.user, fs.pass
+	}
+
+	tp, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := tp.(*target)
+	target.poll = time.Millisecond
+	return target
+}
+
+// eventually waits for the condition to become true.
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	fs := &fakeServer{}
+	target := newTestTarget(t, fs, Config{})
+
+	for i := 0; i < 2; i++ {
+		if err := target.Available(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every request uses a new salt
+	if len(fs.salts) != 2 {
+		t.Errorf("Expected two salts, got %d", len(fs.salts))
+	}
+
+	target = newTestTarget(t, fs, Config{User: "admin", Pass: "wrong"})
+	if err := target.Available(); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected invalid credentials to be fatal, got %v", err)
+	}
+}
+
+func TestScanBatch(t *testing.T) {
+	fs := &fakeServer{}
+	target := newTestTarget(t, fs, Config{Paths: []string{"/music/"}})
+
+	// scans outside of the music folders do not start a scan
+	if err := target.ScanBatch([]autoscan.Scan{{Folder: "/music4k/Queen"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := fs.scanCount(); n != 0 {
+		t.Fatalf("Expected no scans, got %d", n)
+	}
+
+	scans := []autoscan.Scan{{Folder: "/music/Queen"}, {Folder: "/music/ABBA"}}
+	if err := target.ScanBatch(scans); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := fs.scanCount(); n != 1 {
+		t.Fatalf("Expected a single scan for the batch, got %d", n)
+	}
+
+	// scans arriving while the server is scanning are coalesced into a single rescan
+	for _, scan := range scans {
+		if err := target.Scan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := fs.scanCount(); n != 1 {
+		t.Fatalf("Expected the rescan to wait for the scan in progress, got %d scans", n)
+	}
+
+	fs.set(func(s *fakeServer) { s.scanning = false })
+	eventually(t, func() bool { return fs.scanCount() == 2 })
+
+	time.Sleep(20 * time.Millisecond)
+	if n := fs.scanCount(); n != 2 {
+		t.Errorf("Expected a single rescan, got %d scans", n)
+	}
+}
+
+func TestRescanStatusFailures(t *testing.T) {
+	fs := &fakeServer{}
+	target := newTestTarget(t, fs, Config{})
+
+	fs.set(func(s *fakeServer) { s.scanning = true })
+	if err := target.Scan(autoscan.Scan{Folder: "/music/Queen"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending rescan is given up when the scan status keeps failing
+	fs.set(func(s *fakeServer) { s.statusCode = http.StatusServiceUnavailable })
+	eventually(t, func() bool {
+		target.rescan.mu.Lock()
+		defer target.rescan.mu.Unlock()
+
+		return !target.rescan.pending
+	})
+
+	if n := fs.scanCount(); n != 0 {
+		t.Errorf("Expected no scans, got %d", n)
+	}
+}
+
+func TestMusicFolder(t *testing.T) {
+	target := target{paths: []string{"/music/", "/audiobooks"}}
+
+	for folder, expected := range map[string]bool{
+		"/music":                   true,
+		"/music/Queen":             true,
+		"/audiobooks/Dune":         true,
+		"/music4k/Queen":           false,
+		"/audiobooks-archive/Dune": false,
+		"/data/music/Queen":        false,
+	} {
+		if got := target.musicFolder(folder); got != expected {
+			t.Errorf("musicFolder(%q) = %v, want %v", folder, got, expected)
+		}
+	}
+}
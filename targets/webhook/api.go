@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type apiClient struct {
+	client  *http.Client
+	log     zerolog.Logger
+	headers map[string]string
+}
+
+func newAPIClient(headers map[string]string, log zerolog.Logger) apiClient {
+	return apiClient{
+		client:  &http.Client{},
+		log:     log,
+		headers: headers,
+	}
+}
+
+func (c apiClient) do(req *http.Request) (*http.Response, error) {
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, autoscan.ErrTargetUnavailable)
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	c.log.Trace().
+		Stringer("request_url", res.Request.URL).
+		Int("response_status", res.StatusCode).
+		Msg("Request failed")
+
+	// statusCode not in the 2xx range, close response
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 401, 403:
+		return nil, fmt.Errorf("invalid webhook credentials: %s: %w", res.Status, autoscan.ErrFatal)
+	case 404, 408, 429, 500, 502, 503, 504:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrTargetUnavailable)
+	default:
+		return nil, fmt.Errorf("%s: %w", res.Status, autoscan.ErrFatal)
+	}
+}
+
+func (c apiClient) Available(probeURL string) error {
+	req, err := http.NewRequest("GET", probeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating probe request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("availability: %w", err)
+	}
+
+	res.Body.Close()
+	return nil
+}
+
+func (c apiClient) Send(method string, reqURL string, body string) error {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed creating webhook request: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	res.Body.Close()
+	return nil
+}
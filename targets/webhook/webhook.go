@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type Config struct {
+	URL       string             `yaml:"url"`
+	Method    string             `yaml:"method"`
+	Headers   map[string]string  `yaml:"headers"`
+	Body      string             `yaml:"body"`
+	ProbeURL  string             `yaml:"probe-url"`
+	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity string             `yaml:"verbosity"`
+	DryRun    bool               `yaml:"dry-run"`
+}
+
+// defaultBody sends the scan as JSON when no body is configured.
+const defaultBody = `{"folder":{{ json .Folder }},"file":{{ json .File }},"event":{{ json .Event }},"priority":{{ .Priority }},"time":{{ json .Time }}}`
+
+// A request holds the fields of a scan available to the templates,
+// with the folder and file rewritten.
+type request struct {
+	Folder   string
+	File     string
+	Priority int
+	Time     time.Time
+	Event    autoscan.Event
+}
+
+var funcs = template.FuncMap{
+	// json encodes a value, such as a path, for use within a JSON body.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// query escapes a value, such as a path, for use within the query of the URL.
+	"query": url.QueryEscape,
+}
+
+type target struct {
+	url      string
+	method   string
+	probeURL string
+	urlTmpl  *template.Template
+	bodyTmpl *template.Template
+
+	log     zerolog.Logger
+	rewrite autoscan.Rewriter
+	api     apiClient
+}
+
+func New(c Config) (autoscan.Target, error) {
+	l := autoscan.GetLogger(c.Verbosity).With().
+		Str("target", "webhook").
+		Str("url", c.URL).
+		Logger()
+
+	rewriter, err := autoscan.NewRewriter(c.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.URL == "" {
+		return nil, fmt.Errorf("webhook url is missing: %w", autoscan.ErrFatal)
+	}
+
+	method := strings.ToUpper(c.Method)
+	if method == "" {
+		method = "POST"
+	}
+
+	headers := make(map[string]string, len(c.Headers)+1)
+	for k, v := range c.Headers {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+
+	body := c.Body
+	if body == "" && method != "GET" && method != "HEAD" {
+		body = defaultBody
+
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = "application/json"
+		}
+	}
+
+	urlTmpl, err := template.New("url").Funcs(funcs).Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook url template: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	bodyTmpl, err := template.New("body").Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook body template: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	return &target{
+		url:      c.URL,
+		method:   method,
+		probeURL: c.ProbeURL,
+		urlTmpl:  urlTmpl,
+		bodyTmpl: bodyTmpl,
+
+		log:     l,
+		rewrite: rewriter,
+		api:     newAPIClient(headers, l),
+	}, nil
+}
+
+func (t target) Available() error {
+	if t.probeURL == "" {
+		return nil
+	}
+
+	return t.api.Available(t.probeURL)
+}
+
+func (t target) Scan(scan autoscan.Scan) error {
+	r := t.request(scan)
+
+	reqURL, err := render(t.urlTmpl, r)
+	if err != nil {
+		return fmt.Errorf("failed rendering webhook url: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	body, err := render(t.bodyTmpl, r)
+	if err != nil {
+		return fmt.Errorf("failed rendering webhook body: %v: %w", err, autoscan.ErrFatal)
+	}
+
+	l := t.log.With().
+		Str("path", r.Folder).
+		Logger()
+
+	l.Trace().
+		Str("body", body).
+		Msg("Sending webhook request")
+
+	if err := t.api.Send(t.method, reqURL, body); err != nil {
+		return err
+	}
+
+	l.Info().Msg("Scan moved to target")
+	return nil
+}
+
+func (t target) Explain(scan autoscan.Scan) autoscan.Explanation {
+	r := t.request(scan)
+
+	return autoscan.Explanation{
+		Target: "webhook",
+		URL:    t.url,
+		Folder: r.Folder,
+		File:   r.File,
+	}
+}
+
+func (t target) request(scan autoscan.Scan) request {
+	r := request{
+		Folder:   t.rewrite(scan.Folder),
+		Priority: scan.Priority,
+		Time:     scan.Time,
+		Event:    scan.Event,
+	}
+
+	if scan.File != "" {
+		r.File = t.rewrite(scan.File)
+	}
+
+	return r
+}
+
+func render(tmpl *template.Template, r request) (string, error) {
+	sb := new(strings.Builder)
+	if err := tmpl.Execute(sb, r); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudbox/autoscan"
+)
+
+type received struct {
+	Method      string
+	URL         string
+	ContentType string
+	Token       string
+	Body        string
+}
+
+// fakeServer records the requests it receives and responds with the status.
+type fakeServer struct {
+	mu       sync.Mutex
+	status   int
+	requests []received
+}
+
+func (s *fakeServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	s.requests = append(s.requests, received{
+		Method:      r.Method,
+		URL:         r.URL.RequestURI(),
+		ContentType: r.Header.Get("Content-Type"),
+		Token:       r.Header.Get("X-Token"),
+		Body:        string(body),
+	})
+
+	if s.status != 0 {
+		rw.WriteHeader(s.status)
+	}
+}
+
+func newTestTarget(t *testing.T, fs *fakeServer, c Config) autoscan.Target {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	c.URL = srv.URL + c.URL
+	if c.ProbeURL != "" {
+		c.ProbeURL = srv.URL + c.ProbeURL
+	}
+
+	c.Rewrite = []autoscan.Rewrite{{From: "^/mnt/unionfs/Media/", To: "/data/"}}
+
+	target, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func TestScan(t *testing.T) {
+	scan := autoscan.Scan{
+		Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 1",
+		File:     "/mnt/unionfs/Media/TV/Westworld/Season 1/s01e01.mkv",
+		Priority: 5,
+		Event:    autoscan.EventCreated,
+		Time:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	type Test struct {
+		Name     string
+		Config   Config
+		Expected received
+	}
+
+	var testCases = []Test{
+		{
+			Name:   "Sends the scan as JSON by default",
+			Config: Config{URL: "/scan"},
+			Expected: received{
+				Method:      "POST",
+				URL:         "/scan",
+				ContentType: "application/json",
+				Body:        `{"folder":"/data/TV/Westworld/Season 1","file":"/data/TV/Westworld/Season 1/s01e01.mkv","event":"created","priority":5,"time":"2020-01-02T03:04:05Z"}`,
+			},
+		},
+		{
+			Name:   "Keeps the configured content type of the default body",
+			Config: Config{URL: "/scan", Headers: map[string]string{"content-type": "application/vnd.autoscan+json"}},
+			Expected: received{
+				Method:      "POST",
+				URL:         "/scan",
+				ContentType: "application/vnd.autoscan+json",
+				Body:        `{"folder":"/data/TV/Westworld/Season 1","file":"/data/TV/Westworld/Season 1/s01e01.mkv","event":"created","priority":5,"time":"2020-01-02T03:04:05Z"}`,
+			},
+		},
+		{
+			Name:   "Renders the URL and does not send a body with GET",
+			Config: Config{URL: "/scan?path={{ query .Folder }}&event={{ .Event }}", Method: "get"},
+			Expected: received{
+				Method: "GET",
+				URL:    "/scan?path=%2Fdata%2FTV%2FWestworld%2FSeason+1&event=created",
+			},
+		},
+		{
+			Name: "Renders the configured body and headers",
+			Config: Config{
+				URL:     "/scan",
+				Method:  "PUT",
+				Headers: map[string]string{"x-token": "secret"},
+				Body:    `path={{ .File }}`,
+			},
+			Expected: received{
+				Method: "PUT",
+				URL:    "/scan",
+				Token:  "secret",
+				Body:   "path=/data/TV/Westworld/Season 1/s01e01.mkv",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{}
+			target := newTestTarget(t, fs, tc.Config)
+
+			if err := target.Scan(scan); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fs.requests) != 1 {
+				t.Fatalf("Expected a single request, got %d", len(fs.requests))
+			}
+
+			if !reflect.DeepEqual(fs.requests[0], tc.Expected) {
+				t.Errorf("Requests do not match:\n%+v\nvs (expected)\n%+v", fs.requests[0], tc.Expected)
+			}
+		})
+	}
+}
+
+func TestDefaultBody(t *testing.T) {
+	fs := &fakeServer{}
+	target := newTestTarget(t, fs, Config{URL: "/scan"})
+
+	// paths are escaped, and scans without a file send an empty file
+	if err := target.Scan(autoscan.Scan{Folder: `/mnt/unionfs/Media/Movies/"Quoted" \ Movie`}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(fs.requests[0].Body), &body); err != nil {
+		t.Fatalf("Default body is not valid JSON: %v: %s", err, fs.requests[0].Body)
+	}
+
+	if body["folder"] != `/data/Movies/"Quoted" \ Movie` || body["file"] != "" {
+		t.Errorf("Unexpected body: %v", body)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	type Test struct {
+		Name     string
+		Status   int
+		Expected error
+	}
+
+	var testCases = []Test{
+		{"Success", http.StatusNoContent, nil},
+		{"Invalid credentials", http.StatusUnauthorized, autoscan.ErrFatal},
+		{"Forbidden", http.StatusForbidden, autoscan.ErrFatal},
+		{"Not found", http.StatusNotFound, autoscan.ErrTargetUnavailable},
+		{"Too many requests", http.StatusTooManyRequests, autoscan.ErrTargetUnavailable},
+		{"Unavailable", http.StatusServiceUnavailable, autoscan.ErrTargetUnavailable},
+		{"Bad request", http.StatusBadRequest, autoscan.ErrFatal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fs := &fakeServer{status: tc.Status}
+			target := newTestTarget(t, fs, Config{URL: "/scan", ProbeURL: "/health"})
+
+			err := target.Scan(autoscan.Scan{Folder: "/mnt/unionfs/Media/TV/Westworld"})
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Scan errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+
+			err = target.Available()
+			if !errors.Is(err, tc.Expected) || (err == nil) != (tc.Expected == nil) {
+				t.Errorf("Availability errors do not match: %v vs %v (expected)", err, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := New(Config{}); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected a missing URL to be fatal, got %v", err)
+	}
+
+	if _, err := New(Config{URL: "http://localhost", Body: "{{ .Folder"}); !errors.Is(err, autoscan.ErrFatal) {
+		t.Errorf("Expected an invalid body template to be fatal, got %v", err)
+	}
+
+	// without a probe URL, the target is always available
+	target, err := New(Config{URL: "http://localhost:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := target.Available(); err != nil {
+		t.Errorf("Expected the target to be available, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package a_train
 
 import (
-	"encoding/json"
 	"net/http"
 	"time"
 
@@ -17,10 +16,11 @@ type Drive struct {
 }
 
 type Config struct {
-	Drives    []Drive            `yaml:"drives"`
-	Priority  int                `yaml:"priority"`
-	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
-	Verbosity string             `yaml:"verbosity"`
+	Drives      []Drive            `yaml:"drives"`
+	Priority    int                `yaml:"priority"`
+	Rewrite     []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity   string             `yaml:"verbosity"`
+	MaxBodySize int64              `yaml:"max-body-size"`
 }
 
 type ATrainRewriter = func(drive string, input string) string
@@ -53,9 +53,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 
 	trigger := func(callback autoscan.ProcessorFunc) http.Handler {
 		return handler{
-			callback: callback,
-			priority: c.Priority,
-			rewrite:  rewriter,
+			maxBodySize: c.MaxBodySize,
+			callback:    callback,
+			priority:    c.Priority,
+			rewrite:     rewriter,
 		}
 	}
 
@@ -63,9 +64,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 }
 
 type handler struct {
-	priority int
-	rewrite  ATrainRewriter
-	callback autoscan.ProcessorFunc
+	maxBodySize int64
+	priority    int
+	rewrite     ATrainRewriter
+	callback    autoscan.ProcessorFunc
 }
 
 type atrainEvent struct {
@@ -80,10 +82,10 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	drive := chi.URLParam(r, "drive")
 
 	event := new(atrainEvent)
-	err = json.NewDecoder(r.Body).Decode(event)
+	status, err := autoscan.DecodeJSON(rw, r, h.maxBodySize, event)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Failed decoding request")
-		rw.WriteHeader(http.StatusBadRequest)
+		rw.WriteHeader(status)
 		return
 	}
 
@@ -97,6 +99,7 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			Path:     path,
 			Priority: h.priority,
 			Time:     now(),
+			Event:    autoscan.EventCreated,
 		})
 	}
 
@@ -106,13 +109,14 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			Path:     path,
 			Priority: h.priority,
 			Time:     now(),
+			Event:    autoscan.EventDeleted,
 		})
 	}
 
 	err = h.callback(scans...)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Processor could not process scans")
-		rw.WriteHeader(http.StatusInternalServerError)
+		autoscan.WriteProcessorError(rw, err)
 		return
 	}
 
@@ -66,23 +66,31 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/mnt/unionfs/Media/Movies/Interstellar (2014)",
+						Path:     "/Movies/Interstellar (2014)",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Legion/Season 1",
+						Path:     "/TV/Legion/Season 1",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/Movies/Wonder Woman 1984 (2020)",
+						Path:     "/Movies/Wonder Woman 1984 (2020)",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/Movies/Mortal Kombat (2021)",
+						Path:     "/Movies/Mortal Kombat (2021)",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -99,13 +107,17 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/TV/Legion/Season 1",
+						Path:     "/TV/Legion/Season 1",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 					{
 						Folder:   "/TV/Legion/Season 1",
+						Path:     "/TV/Legion/Season 1",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -19,6 +19,10 @@ import (
 
 const (
 	maxSyncRetries = 5
+
+	// queueFullRetry is the time to wait before moving scans
+	// to the processor again when its queue is full.
+	queueFullRetry = time.Minute
 )
 
 type Config struct {
@@ -276,7 +280,18 @@ func (d daemon) startAutoSync() error {
 					Interface("scans", task.scans).
 					Msg("Scans moving to processor")
 
+				// the partial sync has been stored already,
+				// so keep trying until the processor accepts the scans.
 				err := d.callback(task.scans...)
+				for errors.Is(err, autoscan.ErrQueueFull) {
+					l.Warn().
+						Err(err).
+						Msgf("Processor queue is full, retrying in %s", queueFullRetry)
+
+					time.Sleep(queueFullRetry)
+					err = d.callback(task.scans...)
+				}
+
 				if err != nil {
 					return fmt.Errorf("%v: moving scans to processor: %v: %w",
 						drive.ID, err, autoscan.ErrFatal)
@@ -28,7 +28,7 @@ func NewPathsHook(driveID string, store *bds, diff *sqlite.Difference) (bernard.
 		// get folders from diff (that we are interested in)
 		parents, err := getDiffFolders(store, driveID, diff)
 		if err != nil {
-			l.Error().Msgf("getting parents: %w", err)
+			l.Error().Msgf("getting parents: %v", err)
 			return nil
 		}
 
@@ -40,7 +40,7 @@ func NewPathsHook(driveID string, store *bds, diff *sqlite.Difference) (bernard.
 		for _, folder := range rootNewFolders {
 			p, err := getFolderPath(store, driveID, folder.ID, parents.FolderMaps.Current)
 			if err != nil {
-				l.Error().Msgf("building folder path: %v: %w", folder.ID, err)
+				l.Error().Msgf("building folder path: %v: %v", folder.ID, err)
 				continue
 			}
 
@@ -51,7 +51,7 @@ func NewPathsHook(driveID string, store *bds, diff *sqlite.Difference) (bernard.
 		for _, folder := range rootOldFolders {
 			p, err := getFolderPath(store, driveID, folder.ID, parents.FolderMaps.Old)
 			if err != nil {
-				l.Error().Msgf("building old folder path: %v: %w", folder.ID, err)
+				l.Error().Msgf("building old folder path: %v: %v", folder.ID, err)
 				continue
 			}
 
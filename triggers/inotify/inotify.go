@@ -1,6 +1,7 @@
 package inotify
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,6 +15,10 @@ import (
 	"github.com/cloudbox/autoscan"
 )
 
+// queueFullRetry is the time to wait before moving a scan
+// to the processor again when its queue is full.
+const queueFullRetry = time.Minute
+
 type Config struct {
 	Priority  int                `yaml:"priority"`
 	Verbosity string             `yaml:"verbosity"`
@@ -298,7 +303,16 @@ func (q *queue) process() {
 			Time:     time.Now(),
 		})
 
-		if err != nil {
+		if errors.Is(err, autoscan.ErrQueueFull) {
+			// keep the scan and try again later
+			q.log.Warn().
+				Err(err).
+				Str("path", p).
+				Msg("Processor queue is full, retrying scan later")
+
+			q.scans[p] = time.Now().Add(queueFullRetry)
+			continue
+		} else if err != nil {
 			q.log.Error().
 				Err(err).
 				Str("path", p).
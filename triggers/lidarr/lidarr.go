@@ -1,7 +1,6 @@
 package lidarr
 
 import (
-	"encoding/json"
 	"net/http"
 	"path"
 	"strings"
@@ -13,10 +12,11 @@ import (
 )
 
 type Config struct {
-	Name      string             `yaml:"name"`
-	Priority  int                `yaml:"priority"`
-	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
-	Verbosity string             `yaml:"verbosity"`
+	Name        string             `yaml:"name"`
+	Priority    int                `yaml:"priority"`
+	Rewrite     []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity   string             `yaml:"verbosity"`
+	MaxBodySize int64              `yaml:"max-body-size"`
 }
 
 // New creates an autoscan-compatible HTTP Trigger for Lidarr webhooks.
@@ -28,9 +28,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 
 	trigger := func(callback autoscan.ProcessorFunc) http.Handler {
 		return handler{
-			callback: callback,
-			priority: c.Priority,
-			rewrite:  rewriter,
+			maxBodySize: c.MaxBodySize,
+			callback:    callback,
+			priority:    c.Priority,
+			rewrite:     rewriter,
 		}
 	}
 
@@ -38,9 +39,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 }
 
 type handler struct {
-	priority int
-	rewrite  autoscan.Rewriter
-	callback autoscan.ProcessorFunc
+	maxBodySize int64
+	priority    int
+	rewrite     autoscan.Rewriter
+	callback    autoscan.ProcessorFunc
 }
 
 type lidarrEvent struct {
@@ -57,10 +59,10 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	l := hlog.FromRequest(r)
 
 	event := new(lidarrEvent)
-	err = json.NewDecoder(r.Body).Decode(event)
+	status, err := autoscan.DecodeJSON(rw, r, h.maxBodySize, event)
 	if err != nil {
 		l.Error().Err(err).Msg("Failed decoding request")
-		rw.WriteHeader(http.StatusBadRequest)
+		rw.WriteHeader(status)
 		return
 	}
 
@@ -78,6 +80,11 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	change := autoscan.EventCreated
+	if event.Upgrade {
+		change = autoscan.EventModified
+	}
+
 	unique := make(map[string]bool)
 	scans := make([]autoscan.Scan, 0)
 
@@ -93,13 +100,14 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			Folder:   folderPath,
 			Priority: h.priority,
 			Time:     now(),
+			Event:    change,
 		})
 	}
 
 	err = h.callback(scans...)
 	if err != nil {
 		l.Error().Err(err).Msg("Processor could not process scans")
-		rw.WriteHeader(http.StatusInternalServerError)
+		autoscan.WriteProcessorError(rw, err)
 		return
 	}
 
@@ -56,6 +56,7 @@ func TestHandler(t *testing.T) {
 					Folder:   "/mnt/unionfs/Media/Music/Marshmello/Joytime III (2019)",
 					Priority: 5,
 					Time:     currentTime,
+					Event:    autoscan.EventCreated,
 				}},
 			},
 		},
@@ -72,11 +73,13 @@ func TestHandler(t *testing.T) {
 						Folder:   "/mnt/unionfs/Media/Music/blink‐182/California (2016)/CD 01",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/Music/blink‐182/California (2016)/CD 02",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					}},
 			},
 		},
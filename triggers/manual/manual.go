@@ -87,7 +87,7 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	err = h.callback(scans...)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Processor could not process scans")
-		rw.WriteHeader(http.StatusInternalServerError)
+		autoscan.WriteProcessorError(rw, err)
 		return
 	}
 
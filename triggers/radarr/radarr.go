@@ -1,7 +1,6 @@
 package radarr
 
 import (
-	"encoding/json"
 	"net/http"
 	"path"
 	"strings"
@@ -13,10 +12,11 @@ import (
 )
 
 type Config struct {
-	Name      string             `yaml:"name"`
-	Priority  int                `yaml:"priority"`
-	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
-	Verbosity string             `yaml:"verbosity"`
+	Name        string             `yaml:"name"`
+	Priority    int                `yaml:"priority"`
+	Rewrite     []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity   string             `yaml:"verbosity"`
+	MaxBodySize int64              `yaml:"max-body-size"`
 }
 
 // New creates an autoscan-compatible HTTP Trigger for Radarr webhooks.
@@ -28,9 +28,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 
 	trigger := func(callback autoscan.ProcessorFunc) http.Handler {
 		return handler{
-			callback: callback,
-			priority: c.Priority,
-			rewrite:  rewriter,
+			maxBodySize: c.MaxBodySize,
+			callback:    callback,
+			priority:    c.Priority,
+			rewrite:     rewriter,
 		}
 	}
 
@@ -38,13 +39,15 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 }
 
 type handler struct {
-	priority int
-	rewrite  autoscan.Rewriter
-	callback autoscan.ProcessorFunc
+	maxBodySize int64
+	priority    int
+	rewrite     autoscan.Rewriter
+	callback    autoscan.ProcessorFunc
 }
 
 type radarrEvent struct {
-	Type string `json:"eventType"`
+	Type    string `json:"eventType"`
+	Upgrade bool   `json:"isUpgrade"`
 
 	File struct {
 		RelativePath string
@@ -60,10 +63,10 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	rlog := hlog.FromRequest(r)
 
 	event := new(radarrEvent)
-	err = json.NewDecoder(r.Body).Decode(event)
+	status, err := autoscan.DecodeJSON(rw, r, h.maxBodySize, event)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Failed decoding request")
-		rw.WriteHeader(http.StatusBadRequest)
+		rw.WriteHeader(status)
 		return
 	}
 
@@ -75,7 +78,8 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var folderPath string
+	var folderPath, file string
+	var change autoscan.Event
 
 	if strings.EqualFold(event.Type, "Download") || strings.EqualFold(event.Type, "MovieFileDelete") {
 		if event.File.RelativePath == "" || event.Movie.FolderPath == "" {
@@ -84,7 +88,17 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		folderPath = path.Dir(path.Join(event.Movie.FolderPath, event.File.RelativePath))
+		file = path.Join(event.Movie.FolderPath, event.File.RelativePath)
+		folderPath = path.Dir(file)
+
+		switch {
+		case strings.EqualFold(event.Type, "MovieFileDelete"):
+			change = autoscan.EventDeleted
+		case event.Upgrade:
+			change = autoscan.EventModified
+		default:
+			change = autoscan.EventCreated
+		}
 	}
 
 	if strings.EqualFold(event.Type, "MovieDelete") || strings.EqualFold(event.Type, "Rename") {
@@ -95,18 +109,27 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		folderPath = event.Movie.FolderPath
+		change = autoscan.EventRenamed
+		if strings.EqualFold(event.Type, "MovieDelete") {
+			change = autoscan.EventDeleted
+		}
 	}
 
 	scan := autoscan.Scan{
 		Folder:   h.rewrite(folderPath),
 		Priority: h.priority,
 		Time:     now(),
+		Event:    change,
+	}
+
+	if file != "" {
+		scan.File = h.rewrite(file)
 	}
 
 	err = h.callback(scan)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Processor could not process scan")
-		rw.WriteHeader(http.StatusInternalServerError)
+		autoscan.WriteProcessorError(rw, err)
 		return
 	}
 
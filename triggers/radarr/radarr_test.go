@@ -55,8 +55,10 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/mnt/unionfs/Media/Movies/Interstellar (2014)",
+						File:     "/mnt/unionfs/Media/Movies/Interstellar (2014)/Interstellar.2014.UHD.BluRay.2160p.REMUX.mkv",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 				},
 			},
@@ -72,8 +74,10 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/mnt/unionfs/Media/Movies/Tenet (2020)",
+						File:     "/mnt/unionfs/Media/Movies/Tenet (2020)/Tenet.2020.mkv",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -91,6 +95,7 @@ func TestHandler(t *testing.T) {
 						Folder:   "/mnt/unionfs/Media/Movies/Wonder Woman 1984 (2020)",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -108,6 +113,7 @@ func TestHandler(t *testing.T) {
 						Folder:   "/mnt/unionfs/Media/Movies/Deadpool (2016)",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventRenamed,
 					},
 				},
 			},
@@ -56,6 +56,7 @@ func TestHandler(t *testing.T) {
 					Folder:   "/mnt/unionfs/Media/Books/Brandon Sanderson/The Way of Kings (2010)",
 					Priority: 5,
 					Time:     currentTime,
+					Event:    autoscan.EventCreated,
 				}},
 			},
 		},
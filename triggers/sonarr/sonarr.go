@@ -1,7 +1,6 @@
 package sonarr
 
 import (
-	"encoding/json"
 	"net/http"
 	"path"
 	"strings"
@@ -13,10 +12,11 @@ import (
 )
 
 type Config struct {
-	Name      string             `yaml:"name"`
-	Priority  int                `yaml:"priority"`
-	Rewrite   []autoscan.Rewrite `yaml:"rewrite"`
-	Verbosity string             `yaml:"verbosity"`
+	Name        string             `yaml:"name"`
+	Priority    int                `yaml:"priority"`
+	Rewrite     []autoscan.Rewrite `yaml:"rewrite"`
+	Verbosity   string             `yaml:"verbosity"`
+	MaxBodySize int64              `yaml:"max-body-size"`
 }
 
 // New creates an autoscan-compatible HTTP Trigger for Sonarr webhooks.
@@ -28,9 +28,10 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 
 	trigger := func(callback autoscan.ProcessorFunc) http.Handler {
 		return handler{
-			callback: callback,
-			priority: c.Priority,
-			rewrite:  rewriter,
+			maxBodySize: c.MaxBodySize,
+			callback:    callback,
+			priority:    c.Priority,
+			rewrite:     rewriter,
 		}
 	}
 
@@ -38,13 +39,15 @@ func New(c Config) (autoscan.HTTPTrigger, error) {
 }
 
 type handler struct {
-	priority int
-	rewrite  autoscan.Rewriter
-	callback autoscan.ProcessorFunc
+	maxBodySize int64
+	priority    int
+	rewrite     autoscan.Rewriter
+	callback    autoscan.ProcessorFunc
 }
 
 type sonarrEvent struct {
-	Type string `json:"eventType"`
+	Type    string `json:"eventType"`
+	Upgrade bool   `json:"isUpgrade"`
 
 	File struct {
 		RelativePath string
@@ -66,10 +69,10 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	rlog := hlog.FromRequest(r)
 
 	event := new(sonarrEvent)
-	err = json.NewDecoder(r.Body).Decode(event)
+	status, err := autoscan.DecodeJSON(rw, r, h.maxBodySize, event)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Failed decoding request")
-		rw.WriteHeader(http.StatusBadRequest)
+		rw.WriteHeader(status)
 		return
 	}
 
@@ -83,6 +86,12 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	var paths []string
 
+	// the file of a Download or EpisodeFileDelete event
+	var file string
+
+	// the change to the paths
+	var change autoscan.Event
+
 	// a Download event is either an upgrade or a new file.
 	// the EpisodeFileDelete event shares the same request format as Download.
 	if strings.EqualFold(event.Type, "Download") || strings.EqualFold(event.Type, "EpisodeFileDelete") {
@@ -93,8 +102,17 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		// Use path.Dir to get the directory in which the file is located
-		folderPath := path.Dir(path.Join(event.Series.Path, event.File.RelativePath))
-		paths = append(paths, folderPath)
+		file = path.Join(event.Series.Path, event.File.RelativePath)
+		paths = append(paths, path.Dir(file))
+
+		switch {
+		case strings.EqualFold(event.Type, "EpisodeFileDelete"):
+			change = autoscan.EventDeleted
+		case event.Upgrade:
+			change = autoscan.EventModified
+		default:
+			change = autoscan.EventCreated
+		}
 	}
 
 	// An entire show has been deleted
@@ -107,6 +125,7 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 		// Scan the folder of the show
 		paths = append(paths, event.Series.Path)
+		change = autoscan.EventDeleted
 	}
 
 	if strings.EqualFold(event.Type, "Rename") {
@@ -118,6 +137,7 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 		// Keep track of which paths we have already added to paths.
 		encountered := make(map[string]bool)
+		change = autoscan.EventRenamed
 
 		for _, renamedFile := range event.RenamedFiles {
 			previousPath := path.Dir(renamedFile.PreviousPath)
@@ -146,6 +166,11 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			Folder:   folderPath,
 			Priority: h.priority,
 			Time:     now(),
+			Event:    change,
+		}
+
+		if file != "" {
+			scan.File = h.rewrite(file)
 		}
 
 		scans = append(scans, scan)
@@ -154,7 +179,7 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	err = h.callback(scans...)
 	if err != nil {
 		rlog.Error().Err(err).Msg("Processor could not process scans")
-		rw.WriteHeader(http.StatusInternalServerError)
+		autoscan.WriteProcessorError(rw, err)
 		return
 	}
 
@@ -55,8 +55,10 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 1",
+						File:     "/mnt/unionfs/Media/TV/Westworld/Season 1/Westworld.S01E01.mkv",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventCreated,
 					},
 				},
 			},
@@ -72,8 +74,10 @@ func TestHandler(t *testing.T) {
 				Scans: []autoscan.Scan{
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 2",
+						File:     "/mnt/unionfs/Media/TV/Westworld/Season 2/Westworld.S02E01.mkv",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -91,21 +95,25 @@ func TestHandler(t *testing.T) {
 						Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 1",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventRenamed,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Westworld [imdb:tt0475784]/Season 1",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventRenamed,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Westworld/Season 2",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventRenamed,
 					},
 					{
 						Folder:   "/mnt/unionfs/Media/TV/Westworld [imdb:tt0475784]/Season 2",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventRenamed,
 					},
 				},
 			},
@@ -123,6 +131,7 @@ func TestHandler(t *testing.T) {
 						Folder:   "/mnt/unionfs/Media/TV/Westworld",
 						Priority: 5,
 						Time:     currentTime,
+						Event:    autoscan.EventDeleted,
 					},
 				},
 			},
@@ -137,6 +146,20 @@ func TestHandler(t *testing.T) {
 				StatusCode: 400,
 			},
 		},
+		{
+			"Returns request entity too large when the body exceeds the maximum size",
+			Given{
+				Config: Config{
+					Name:        "sonarr",
+					Priority:    5,
+					MaxBodySize: 16,
+				},
+				Fixture: "testdata/westworld.json",
+			},
+			Expected{
+				StatusCode: 413,
+			},
+		},
 		{
 			"Returns 200 on Test event without emitting a scan",
 			Given{
@@ -1,10 +1,16 @@
 package autoscan
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"net/url"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -25,8 +31,96 @@ func DSN(path string, q url.Values) string {
 	return u.String()
 }
 
+// reTitleTags matches the year and ids in folder names, such as (2014), [imdb-tt0816692] or {tvdb-121361}.
+var reTitleTags = regexp.MustCompile(`\s*[([{][^)\]}]*[)\]}]`)
+
+// MediaTitle returns the title of the media a file belongs to, for use with the search API of a target.
+// The title is the first folder of the file within the library root, without the year or any ids.
+// For example, the title of "/data/TV/Westworld (2016) [imdb-tt0475784]/Season 1/Westworld.S01E01.mkv"
+// within the library "/data/TV/" is "Westworld".
+// Files in the library root use the file name without its extension instead.
+func MediaTitle(root string, file string) string {
+	rel := file
+	if root = strings.TrimRight(root, `/\`); len(file) >= len(root) {
+		rel = strings.TrimLeft(file[len(root):], `/\`)
+	}
+
+	title := strings.TrimSuffix(rel, path.Ext(rel))
+	if i := strings.IndexAny(rel, `/\`); i >= 0 {
+		title = rel[:i]
+	}
+
+	return strings.TrimSpace(reTitleTags.ReplaceAllString(title, ""))
+}
+
+// mediaExtensions are the extensions of the files which media servers add to their libraries.
+var mediaExtensions = map[string]bool{
+	".avi": true, ".m2ts": true, ".m4v": true, ".mkv": true, ".mov": true, ".mp4": true, ".mpg": true, ".ts": true, ".webm": true, ".wmv": true,
+	".aac": true, ".alac": true, ".flac": true, ".m4a": true, ".mp3": true, ".ogg": true, ".opus": true, ".wav": true, ".wma": true,
+}
+
+// MediaFiles returns the media files within the folder and its subfolders.
+// The folder may also be a single media file.
+func MediaFiles(folder string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && mediaExtensions[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
 func RcloneForget(args []string) {
 	args = append([]string{"rc", "vfs/forget"}, args...)
 	cmd := exec.Command("rclone", args...)
 	_ = cmd.Run()
 }
+
+// DefaultMaxBodySize is the maximum size of a webhook request body
+// when the trigger does not configure its own limit.
+const DefaultMaxBodySize int64 = 4 << 20
+
+// DecodeJSON decodes the JSON body of a webhook request into v.
+// The body is limited to maxBodySize bytes, or DefaultMaxBodySize when not set.
+// When decoding fails, the returned status code should be sent to the client.
+func DecodeJSON(rw http.ResponseWriter, r *http.Request, maxBodySize int64, v interface{}) (int, error) {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxBodySize)).Decode(v)
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		return http.StatusOK, nil
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, err
+	default:
+		return http.StatusBadRequest, err
+	}
+}
+
+// queueFullRetryAfter is the number of seconds a client should wait
+// before retrying a request rejected because of a full queue.
+const queueFullRetryAfter = "60"
+
+// WriteProcessorError writes the status code matching an error returned by the ProcessorFunc.
+// A full queue results in 503 Service Unavailable, so the client retries the request later.
+func WriteProcessorError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, ErrQueueFull) {
+		rw.Header().Set("Retry-After", queueFullRetryAfter)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	rw.WriteHeader(http.StatusInternalServerError)
+}
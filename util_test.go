@@ -1,6 +1,10 @@
 package autoscan
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 )
@@ -57,3 +61,85 @@ func TestDSN(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteProcessorError(t *testing.T) {
+	type Test struct {
+		Name           string
+		Err            error
+		WantStatusCode int
+		WantRetryAfter string
+	}
+
+	var testCases = []Test{
+		{
+			Name:           "Queue full",
+			Err:            fmt.Errorf("10 scans queued: %w", ErrQueueFull),
+			WantStatusCode: http.StatusServiceUnavailable,
+			WantRetryAfter: queueFullRetryAfter,
+		},
+		{
+			Name:           "Other errors",
+			Err:            errors.New("database is locked"),
+			WantStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			WriteProcessorError(rw, tc.Err)
+
+			if rw.Code != tc.WantStatusCode {
+				t.Errorf("Status codes do not match: %d, want %d", rw.Code, tc.WantStatusCode)
+			}
+
+			if retryAfter := rw.Header().Get("Retry-After"); retryAfter != tc.WantRetryAfter {
+				t.Errorf("Retry-After does not match: %q, want %q", retryAfter, tc.WantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestMediaTitle(t *testing.T) {
+	type Test struct {
+		Name string
+		Root string
+		File string
+		Want string
+	}
+
+	var testCases = []Test{
+		{
+			Name: "Episode",
+			Root: "/data/TV/",
+			File: "/data/TV/Westworld (2016) [imdb-tt0475784]/Season 1/Westworld.S01E01.mkv",
+			Want: "Westworld",
+		},
+		{
+			Name: "Movie",
+			Root: "/data/Movies",
+			File: "/data/Movies/Interstellar (2014) {tmdb-157336}/Interstellar.mkv",
+			Want: "Interstellar",
+		},
+		{
+			Name: "File in the library root",
+			Root: "/data/Movies/",
+			File: "/data/Movies/Interstellar (2014).mkv",
+			Want: "Interstellar",
+		},
+		{
+			Name: "Windows paths",
+			Root: `D:\Media\Music\`,
+			File: `D:\Media\Music\Queen\A Night at the Opera\01 - Death on Two Legs.flac`,
+			Want: "Queen",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := MediaTitle(tc.Root, tc.File); got != tc.Want {
+				t.Errorf("MediaTitle(%q, %q) = %q, want %q", tc.Root, tc.File, got, tc.Want)
+			}
+		})
+	}
+}